@@ -0,0 +1,126 @@
+package netguard
+
+import (
+	"fmt"
+	"net"
+)
+
+// AddressClass IP地址的分类
+type AddressClass int
+
+const (
+	AddressPublic    AddressClass = iota // 公网地址
+	AddressPrivate                       // 私有地址，包括 RFC 1918、运营商级NAT、链路本地和IPv6唯一本地地址
+	AddressLoopback                      // 环回地址
+	AddressMulticast                     // 组播地址
+	AddressReserved                      // 文档、基准测试、未指定地址等保留地址
+	AddressInternal                      // 通过 WithInternalNetworks 设置的内部网络
+)
+
+func (c AddressClass) String() string {
+	switch c {
+	case AddressPrivate:
+		return "私有"
+	case AddressLoopback:
+		return "环回"
+	case AddressMulticast:
+		return "组播"
+	case AddressReserved:
+		return "保留"
+	case AddressInternal:
+		return "内部"
+	}
+	return "公网"
+}
+
+// specialNetwork 不代表互联网上真实公网主机的地址段
+type specialNetwork struct {
+	cidr  string
+	class AddressClass
+	desc  string
+}
+
+// specialNetworkTable 默认的地址分类。IPv4映射的IPv6地址(::ffff:192.168.1.1)按IPv4地址判断
+var specialNetworkTable = []specialNetwork{
+	// ---------- IPv4 ----------
+	{"0.0.0.0/8", AddressReserved, "本网络，RFC 1122。通常用作默认路由或表示无效地址"},
+	{"10.0.0.0/8", AddressPrivate, "A类私有地址，RFC 1918"},
+	{"100.64.0.0/10", AddressPrivate, "运营商级NAT共享地址，RFC 6598"},
+	{"127.0.0.0/8", AddressLoopback, "环回地址，RFC 1122"},
+	{"169.254.0.0/16", AddressPrivate, "链路本地地址，RFC 3927。DHCP失败时系统自动分配"},
+	{"172.16.0.0/12", AddressPrivate, "B类私有地址，RFC 1918"},
+	{"192.0.0.0/24", AddressReserved, "IETF协议分配，RFC 6890"},
+	{"192.0.2.0/24", AddressReserved, "文档地址 TEST-NET-1，RFC 5737"},
+	{"192.168.0.0/16", AddressPrivate, "C类私有地址，RFC 1918"},
+	{"198.18.0.0/15", AddressReserved, "网络设备基准测试，RFC 2544"},
+	{"198.51.100.0/24", AddressReserved, "文档地址 TEST-NET-2，RFC 5737"},
+	{"203.0.113.0/24", AddressReserved, "文档地址 TEST-NET-3，RFC 5737"},
+	{"224.0.0.0/4", AddressMulticast, "组播地址，RFC 5771"},
+	{"240.0.0.0/4", AddressReserved, "保留地址，含广播地址 255.255.255.255，RFC 1112"},
+
+	// ---------- IPv6 ----------
+	{"::/128", AddressReserved, "未指定地址，RFC 4291"},
+	{"::1/128", AddressLoopback, "环回地址，RFC 4291"},
+	{"100::/64", AddressReserved, "丢弃地址，RFC 6666"},
+	{"2001:db8::/32", AddressReserved, "文档地址，RFC 3849"},
+	{"3fff::/20", AddressReserved, "文档地址，RFC 9637"},
+	{"fc00::/7", AddressPrivate, "唯一本地地址ULA，类似IPv4的私有地址，RFC 4193"},
+	{"fe80::/10", AddressPrivate, "链路本地地址，RFC 4291"},
+	{"ff00::/8", AddressMulticast, "组播地址，RFC 4291"},
+}
+
+// AddressClassifier 按最长前缀匹配对IP地址分类
+type AddressClassifier struct {
+	trie prefixTrie
+}
+
+// NewAddressClassifier 创建地址分类器。默认分类见 specialNetworkTable，
+// internal 为自定义的内部网络，与默认地址段重叠时按最长前缀匹配，前缀相同时内部网络优先
+func NewAddressClassifier(internal ...*net.IPNet) *AddressClassifier {
+	c := &AddressClassifier{}
+	for _, n := range specialNetworkTable {
+		_, ipnet, err := net.ParseCIDR(n.cidr)
+		if err != nil {
+			panic(fmt.Errorf("无效的地址段 %s: %w", n.cidr, err))
+		}
+		c.trie.insert(ipnet, n.class)
+	}
+	for _, n := range internal {
+		c.trie.insert(n, AddressInternal)
+	}
+	return c
+}
+
+// Classify 获取IP地址的分类。不属于任何地址段的为公网地址
+func (c *AddressClassifier) Classify(ip net.IP) AddressClass {
+	class, _ := c.trie.lookup(ip)
+	return class
+}
+
+// WithInternalNetworks 设置内部网络，如公司VPN网段、办公室的公网网段。
+// 内部网络的地址分类为 AddressInternal，IsNativeIP 返回 true，不再查询地理位置。
+func WithInternalNetworks(nets ...*net.IPNet) Option {
+	return func(e *Engine) {
+		e.classifier.Store(NewAddressClassifier(nets...))
+	}
+}
+
+// SetInternalNetworks 设置默认引擎的内部网络
+func SetInternalNetworks(nets ...*net.IPNet) {
+	DefaultEngine().SetInternalNetworks(nets...)
+}
+
+// SetInternalNetworks 设置内部网络。每次调用都替换之前设置的内部网络
+func (e *Engine) SetInternalNetworks(nets ...*net.IPNet) {
+	e.classifier.Store(NewAddressClassifier(nets...))
+}
+
+// ClassifyAddress 按默认引擎的内部网络获取IP地址的分类
+func ClassifyAddress(ip net.IP) AddressClass {
+	return DefaultEngine().ClassifyAddress(ip)
+}
+
+// ClassifyAddress 获取IP地址的分类
+func (e *Engine) ClassifyAddress(ip net.IP) AddressClass {
+	return e.classifier.Load().Classify(ip)
+}
@@ -0,0 +1,114 @@
+package netguard
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/gopacket/pcap"
+)
+
+// DEFAULT_SNAP_LEN 默认每个数据包最多捕获的字节数，略大于标准 MTU 1500 字节
+const DEFAULT_SNAP_LEN = 1600
+
+// CaptureOptions 网卡抓包参数
+type CaptureOptions struct {
+	SnapLen       int           // 每个数据包最多捕获的字节数。0 表示使用 DEFAULT_SNAP_LEN
+	Promiscuous   bool          // 是否开启混杂模式，捕获所有经过网卡的数据包。部分网络禁止混杂模式
+	BufferSize    int           // 内核抓包缓冲区的字节数。流量大时调大可减少丢包，0 表示使用系统默认值
+	Timeout       time.Duration // 读超时，缓冲区未满时最多等待多久返回数据包。0 表示无限期等待
+	ImmediateMode bool          // 立即模式，数据包到达后立即交给程序，不等待缓冲区填满。开启后忽略 Timeout
+}
+
+// DefaultCaptureOptions 默认的抓包参数：捕获 1600 字节，开启混杂模式，无限期等待
+func DefaultCaptureOptions() CaptureOptions {
+	return CaptureOptions{
+		SnapLen:     DEFAULT_SNAP_LEN,
+		Promiscuous: true,
+	}
+}
+
+// WithCaptureOptions 设置网卡抓包参数，默认为 DefaultCaptureOptions。
+// 未设置的字段为零值，如需混杂模式要显式设置 Promiscuous，建议在 DefaultCaptureOptions 的基础上修改：
+//
+//	opts := netguard.DefaultCaptureOptions()
+//	opts.Promiscuous = false
+//	opts.BufferSize = 64 * 1024 * 1024
+//	e := netguard.NewEngine(netguard.WithCaptureOptions(opts))
+func WithCaptureOptions(opts CaptureOptions) Option {
+	return func(e *Engine) {
+		e.captureOpts = opts
+	}
+}
+
+// SetCaptureOptions 设置网卡抓包参数。需在开始抓包前设置
+func (e *Engine) SetCaptureOptions(opts CaptureOptions) {
+	e.captureOpts = opts
+}
+
+// inactiveHandle 未激活的抓包句柄，由 *pcap.InactiveHandle 实现
+type inactiveHandle interface {
+	SetSnapLen(snaplen int) error
+	SetPromisc(promisc bool) error
+	SetTimeout(timeout time.Duration) error
+	SetBufferSize(bufferSize int) error
+	SetImmediateMode(mode bool) error
+}
+
+// captureHandle 已打开的网卡抓包句柄，由 *pcap.Handle 实现
+type captureHandle interface {
+	packetReader
+	SetBPFFilter(expr string) error
+}
+
+// openCaptureFunc 按抓包参数打开网卡
+type openCaptureFunc func(devName string, opts CaptureOptions) (captureHandle, error)
+
+// openLive 按抓包参数打开网卡。
+// 与 pcap.OpenLive 不同，使用 pcap.NewInactiveHandle 以便设置内核缓冲区大小和立即模式
+func openLive(devName string, opts CaptureOptions) (captureHandle, error) {
+	inactive, err := pcap.NewInactiveHandle(devName)
+	if err != nil {
+		return nil, err
+	}
+	defer inactive.CleanUp()
+	if err = applyCaptureOptions(inactive, opts); err != nil {
+		return nil, err
+	}
+	handle, err := inactive.Activate()
+	if err != nil {
+		return nil, err
+	}
+	return handle, nil
+}
+
+// applyCaptureOptions 在激活前设置抓包参数。SnapLen 和 Timeout 为零值时使用默认值
+func applyCaptureOptions(inactive inactiveHandle, opts CaptureOptions) error {
+	snapLen := opts.SnapLen
+	if snapLen <= 0 {
+		snapLen = DEFAULT_SNAP_LEN
+	}
+	if err := inactive.SetSnapLen(snapLen); err != nil {
+		return fmt.Errorf("设置抓包长度(%d)失败: %w", snapLen, err)
+	}
+	if err := inactive.SetPromisc(opts.Promiscuous); err != nil {
+		return fmt.Errorf("设置混杂模式(%v)失败: %w", opts.Promiscuous, err)
+	}
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = pcap.BlockForever
+	}
+	if err := inactive.SetTimeout(timeout); err != nil {
+		return fmt.Errorf("设置读超时(%s)失败: %w", opts.Timeout, err)
+	}
+	if opts.BufferSize > 0 {
+		if err := inactive.SetBufferSize(opts.BufferSize); err != nil {
+			return fmt.Errorf("设置缓冲区大小(%d)失败: %w", opts.BufferSize, err)
+		}
+	}
+	if opts.ImmediateMode {
+		if err := inactive.SetImmediateMode(true); err != nil {
+			return fmt.Errorf("设置立即模式失败: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package netguard
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// 容器ID为64位十六进制字符串，出现在 cgroup 路径中，如：
+//
+//	/docker/<id>                                    (cgroup v1, Docker)
+//	/system.slice/docker-<id>.scope                 (cgroup v2, Docker)
+//	/kubepods.slice/.../cri-containerd-<id>.scope   (Kubernetes + containerd)
+//	/.../libpod-<id>.scope                          (Podman)
+var containerIDPattern = regexp.MustCompile(`[0-9a-f]{64}`)
+
+// readContainerID 读取 <root>/<pid>/cgroup，返回进程所在容器的ID。不在容器中或读取失败时返回空字符串
+func readContainerID(root string, pid int32) string {
+	f, err := os.Open(filepath.Join(root, strconv.Itoa(int(pid)), "cgroup"))
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if id := containerIDFromCgroup(scanner.Text()); id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+// containerIDFromCgroup 从 /proc/<pid>/cgroup 的一行 "层级ID:控制器:路径" 中解析容器ID
+func containerIDFromCgroup(line string) string {
+	parts := strings.SplitN(line, ":", 3)
+	if len(parts) != 3 {
+		return ""
+	}
+	// 取路径中最后一个容器ID，嵌套容器时为最内层的容器
+	ids := containerIDPattern.FindAllString(parts[2], -1)
+	if len(ids) == 0 {
+		return ""
+	}
+	return ids[len(ids)-1]
+}
@@ -0,0 +1,47 @@
+package netguard
+
+import "testing"
+
+// 添加测试：从 testdata/procfs 中的 cgroup 文件解析 Docker、Kubernetes、Podman 容器ID
+func TestReadContainerID(t *testing.T) {
+	for _, tt := range []struct {
+		pid  int32
+		name string
+		want string
+	}{
+		{101, "Docker cgroup v1", "3f4e8b0c1d2a5f6e7b8c9d0a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b0c"},
+		{102, "Docker cgroup v2", "9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f1a0b9c8d7e6f5a4b3c2d1e0f9a8b"},
+		{103, "Kubernetes containerd", "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"},
+		{104, "Podman", "fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210"},
+		{105, "宿主机进程", ""},
+		{999, "进程不存在", ""},
+	} {
+		if got := readContainerID("testdata/procfs", tt.pid); got != tt.want {
+			t.Errorf("%s: 容器ID应为 %q，实际 %q", tt.name, tt.want, got)
+		}
+	}
+}
+
+// 添加测试：按容器汇总流量
+func TestProcessStatsGroupByContainer(t *testing.T) {
+	e := NewEngine()
+	const c1 = "3f4e8b0c1d2a5f6e7b8c9d0a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b0c"
+	for i, tr := range []*TrafficRecord{
+		{ProcessPID: 10, ContainerID: c1, BytesSent: 100},
+		{ProcessPID: 11, ContainerID: c1, BytesSent: 200},
+		{ProcessPID: 20, BytesSent: 50},
+	} {
+		tr.RemoteIP = []byte{1, 1, 1, byte(i)}
+		e.trafficMap.Store(i, tr)
+	}
+	stats := e.GetProcessStatsBy(GroupByContainer)
+	if len(stats) != 2 {
+		t.Fatalf("应按容器汇总为 2 条，实际 %d", len(stats))
+	}
+	if stats[0].ContainerID != c1 || stats[0].BytesSent != 300 || stats[0].ProcessCount != 2 || stats[0].ProcessPID != 0 {
+		t.Fatalf("容器的汇总不正确: %+v", stats[0])
+	}
+	if stats[1].ContainerID != "" || stats[1].BytesSent != 50 {
+		t.Fatalf("宿主机进程应汇总为容器ID为空的一条: %+v", stats[1])
+	}
+}
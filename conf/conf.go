@@ -3,8 +3,10 @@ package conf
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 
 	"github.com/iotames/easyconf"
+	"github.com/iotames/netguard"
 )
 
 var cf *easyconf.Conf
@@ -25,6 +27,15 @@ const DEFAULT_DB_NAME = "netguard"
 const DEFAULT_DB_SCHEMA = "public"
 const DEFAULT_DB_USERNAME = "postgres"
 const DEFAULT_DB_PASSWORD = "postgres"
+const DEFAULT_PCAP_RECORD_DIR = "pcap"
+const DEFAULT_PCAP_RECORD_MAX_SIZE_MB = 100
+const DEFAULT_PCAP_RECORD_ROTATE_MINUTES = 60
+const DEFAULT_PCAP_RECORD_MAX_FILES = 24
+const DEFAULT_PROCESS_RESOLVER = "gopsutil"
+const DEFAULT_BPF_FILTER = netguard.DefaultBPFFilter
+const DEFAULT_CAPTURE_SNAPLEN = 1600
+const DEFAULT_CAPTURE_BUFFER_MB = 0
+const DEFAULT_CAPTURE_TIMEOUT_MS = 0
 
 var RuntimeDir string
 
@@ -36,6 +47,15 @@ var ShowSql bool
 var DbDriver, DbHost, DbName, DbSchema, DbUsername, DbPassword string
 var DbPort int
 
+var PcapRecord bool
+var PcapRecordMaxSizeMB, PcapRecordRotateMinutes, PcapRecordMaxFiles int
+
+var ProcessResolver string
+var BpfFilter string
+var LocalNetworks, InternalCIDRs string
+var CaptureSnapLen, CaptureBufferMB, CaptureTimeoutMS int
+var CapturePromisc, CaptureImmediate, CaptureLoopback bool
+
 func getEnvFile() string {
 	efile := os.Getenv("NGD_ENV_FILE")
 	if efile == "" {
@@ -62,6 +82,22 @@ func setConfByEnv() error {
 	cf.StringVar(&DbUsername, "DB_USERNAME", DEFAULT_DB_USERNAME, "数据库用户名")
 	cf.StringVar(&DbPassword, "DB_PASSWORD", DEFAULT_DB_PASSWORD, "数据库密码")
 
+	cf.BoolVar(&PcapRecord, "PCAP_RECORD", false, "是否把抓到的原始数据包录制到RUNTIME_DIR/pcap目录下的pcapng文件")
+	cf.IntVar(&PcapRecordMaxSizeMB, "PCAP_RECORD_MAX_SIZE_MB", DEFAULT_PCAP_RECORD_MAX_SIZE_MB, "单个录制文件的最大大小(MB)，超过后切换新文件。0表示不限制")
+	cf.IntVar(&PcapRecordRotateMinutes, "PCAP_RECORD_ROTATE_MINUTES", DEFAULT_PCAP_RECORD_ROTATE_MINUTES, "单个录制文件的最长录制时长(分钟)，超过后切换新文件。0表示不限制")
+	cf.IntVar(&PcapRecordMaxFiles, "PCAP_RECORD_MAX_FILES", DEFAULT_PCAP_RECORD_MAX_FILES, "最多保留的录制文件数，超过后删除最旧的文件。0表示不限制")
+
+	cf.StringVar(&ProcessResolver, "PROCESS_RESOLVER", DEFAULT_PROCESS_RESOLVER, "查找连接所属进程的方式: gopsutil(所有平台),procfs(Linux),netlink(Linux)")
+	cf.IntVar(&CaptureSnapLen, "CAPTURE_SNAPLEN", DEFAULT_CAPTURE_SNAPLEN, "每个数据包最多捕获的字节数")
+	cf.BoolVar(&CapturePromisc, "CAPTURE_PROMISC", true, "是否开启混杂模式，捕获所有经过网卡的数据包。部分网络禁止混杂模式")
+	cf.IntVar(&CaptureBufferMB, "CAPTURE_BUFFER_MB", DEFAULT_CAPTURE_BUFFER_MB, "内核抓包缓冲区大小(MB)，流量大时调大可减少丢包。0表示使用系统默认值")
+	cf.IntVar(&CaptureTimeoutMS, "CAPTURE_TIMEOUT_MS", DEFAULT_CAPTURE_TIMEOUT_MS, "抓包读超时(毫秒)。0表示无限期等待")
+	cf.BoolVar(&CaptureImmediate, "CAPTURE_IMMEDIATE", false, "是否开启立即模式，数据包到达后立即处理，不等待缓冲区填满")
+	cf.BoolVar(&CaptureLoopback, "CAPTURE_LOOPBACK", false, "是否同时监控环回网卡，统计本机进程之间(如访问127.0.0.1)的流量")
+	cf.StringVar(&LocalNetworks, "LOCAL_NETWORKS", "", "本地网络(CIDR)，逗号分隔，用于判断流量方向。两端都不是本机IP或本地网络的为转发流量。如: 192.168.1.0/24,fd00::/64")
+	cf.StringVar(&InternalCIDRs, "INTERNAL_CIDRS", "", "内部网络(CIDR)，逗号分隔。如公司VPN网段、办公室的公网网段，这些地址视为内部地址，不查询地理位置")
+	cf.StringVar(&BpfFilter, "BPF_FILTER", DEFAULT_BPF_FILTER, "网卡抓包的BPF过滤器，语法同tcpdump。如: tcp port 443 or udp port 53")
+
 	return cf.Parse(false)
 }
 
@@ -91,6 +127,11 @@ func LoadEnv() error {
 	return err
 }
 
+// GetPcapRecordDir 抓包录制文件的保存目录
+func GetPcapRecordDir() string {
+	return filepath.Join(RuntimeDir, DEFAULT_PCAP_RECORD_DIR)
+}
+
 // IsPathExists 判断文件或文件夹是否存在
 func IsPathExists(path string) bool {
 	_, err := os.Stat(path)
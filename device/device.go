@@ -8,6 +8,9 @@ import (
 	// "github.com/iotames/netguard/log"
 )
 
+// pcap_if_t 的 PCAP_IF_LOOPBACK 标志
+const pcapIfLoopback = 0x00000001
+
 func GetDeviceList() []pcap.Interface {
 	devices, err := pcap.FindAllDevs()
 	if err != nil {
@@ -56,3 +59,29 @@ func GetDefaultDevice() pcap.Interface {
 	}
 	return defaultDevice
 }
+
+// GetNonLoopbackDevices 获取所有有IP地址的非环回网络设备，用于同时监控多个网卡。
+// 没有IP地址的伪设备（如 Linux 的 any、nflog）不包含在内
+func GetNonLoopbackDevices() []pcap.Interface {
+	var devs []pcap.Interface
+	for _, device := range GetDeviceList() {
+		for _, addr := range device.Addresses {
+			if addr.IP != nil && !addr.IP.IsLoopback() {
+				devs = append(devs, device)
+				break
+			}
+		}
+	}
+	return devs
+}
+
+// GetLoopbackDevices 获取环回网络设备，如 Linux 的 lo、Windows Npcap 的 NPF_Loopback
+func GetLoopbackDevices() []pcap.Interface {
+	var devs []pcap.Interface
+	for _, device := range GetDeviceList() {
+		if device.Flags&pcapIfLoopback != 0 {
+			devs = append(devs, device)
+		}
+	}
+	return devs
+}
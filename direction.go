@@ -0,0 +1,84 @@
+package netguard
+
+import (
+	"bytes"
+	"net"
+)
+
+// Direction 数据包相对本机的方向
+type Direction int
+
+const (
+	DirectionOutbound Direction = iota // 出站：从本机或本地网络发出
+	DirectionInbound                   // 入站：发往本机或本地网络
+	DirectionTransit                   // 转发：两端都不是本机或本地网络，如网关转发、混杂模式下其他主机之间的流量
+)
+
+func (d Direction) String() string {
+	switch d {
+	case DirectionInbound:
+		return "入站"
+	case DirectionTransit:
+		return "转发"
+	}
+	return "出站"
+}
+
+// WithLocalNetworks 设置本地网络，用于判断流量方向。
+// 本机IP以外，这些网络中的地址也作为本地一端，如监控交换机镜像端口时的局域网网段。
+// 两端都不是本机IP或本地网络的流量为转发流量，单独统计，见 GetTransitStats。
+func WithLocalNetworks(nets ...*net.IPNet) Option {
+	return func(e *Engine) {
+		e.localNets = nets
+	}
+}
+
+// SetLocalNetworks 设置本地网络。需在开始抓包前设置
+func (e *Engine) SetLocalNetworks(nets ...*net.IPNet) {
+	e.localNets = nets
+}
+
+// inLocalNetworks 判断IP是否属于 WithLocalNetworks 设置的本地网络
+func (e *Engine) inLocalNetworks(ip net.IP) bool {
+	for _, n := range e.localNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// classifyPacket 判断数据包的方向。
+// 本机IP优先于本地网络：发往本机的为入站，从本机发出的为出站，然后再按本地网络判断。
+// 两端都在本地网络时，与转发流量一样以地址较小的一端作为本地一端，使两个方向的数据包记为同一条连接。
+// hostLocal 表示本地一端是本机IP，只有这类连接才需要查找本机进程。两端都是本机IP的情况由调用方单独处理。
+func (e *Engine) classifyPacket(srcIP net.IP, srcPort uint16, dstIP net.IP, dstPort uint16) (dir Direction, hostLocal bool) {
+	switch {
+	case e.isLocalIP(dstIP):
+		return DirectionInbound, true
+	case e.isLocalIP(srcIP):
+		return DirectionOutbound, true
+	}
+	srcNet, dstNet := e.inLocalNetworks(srcIP), e.inLocalNetworks(dstIP)
+	switch {
+	case srcNet && dstNet:
+		if transitEndpoints(srcIP, srcPort, dstIP, dstPort) {
+			return DirectionOutbound, false
+		}
+		return DirectionInbound, false
+	case dstNet:
+		return DirectionInbound, false
+	case srcNet:
+		return DirectionOutbound, false
+	}
+	return DirectionTransit, false
+}
+
+// transitEndpoints 转发流量两个方向的数据包使用同一条记录：地址较小的一端作为 LocalIP。
+// 返回 true 表示数据包从 LocalIP 一端发出
+func transitEndpoints(srcIP net.IP, srcPort uint16, dstIP net.IP, dstPort uint16) bool {
+	if c := bytes.Compare(srcIP.To16(), dstIP.To16()); c != 0 {
+		return c < 0
+	}
+	return srcPort <= dstPort
+}
@@ -0,0 +1,102 @@
+package netguard
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// 添加测试：按本机IP和本地网络判断入站、出站、转发
+func TestClassifyPacket(t *testing.T) {
+	_, lan, _ := net.ParseCIDR("10.1.0.0/16")
+	e := NewEngine(WithLocalIPs(net.ParseIP("192.168.1.10")), WithLocalNetworks(lan))
+	cases := []struct {
+		src, dst  string
+		dir       Direction
+		hostLocal bool
+	}{
+		{"93.184.216.34", "192.168.1.10", DirectionInbound, true},
+		{"192.168.1.10", "93.184.216.34", DirectionOutbound, true},
+		{"10.1.2.3", "192.168.1.10", DirectionInbound, true},
+		{"10.1.2.3", "93.184.216.34", DirectionOutbound, false},
+		{"93.184.216.34", "10.1.2.3", DirectionInbound, false},
+		// 两端都在本地网络：地址较小的一端为本地一端，应答数据包为入站
+		{"10.1.2.3", "10.1.4.5", DirectionOutbound, false},
+		{"10.1.4.5", "10.1.2.3", DirectionInbound, false},
+		{"172.16.0.5", "93.184.216.34", DirectionTransit, false},
+	}
+	for _, c := range cases {
+		dir, hostLocal := e.classifyPacket(net.ParseIP(c.src), 40000, net.ParseIP(c.dst), 443)
+		if dir != c.dir || hostLocal != c.hostLocal {
+			t.Errorf("%s -> %s 应为 %s/%v，实际 %s/%v", c.src, c.dst, c.dir, c.hostLocal, dir, hostLocal)
+		}
+	}
+}
+
+// 添加测试：转发流量两个方向合并为一条记录，单独统计且不查找进程
+func TestTransitFlows(t *testing.T) {
+	m := newMockResolver()
+	_, lan, _ := net.ParseCIDR("10.1.0.0/16")
+	e := NewEngine(WithProcessResolver(m), WithLocalIPs(net.ParseIP("192.168.1.10")), WithLocalNetworks(lan))
+	send := func(data []byte) {
+		e.processCapturedPacket(gopacket.NewPacket(data, layers.LinkTypeEthernet, gopacket.Default), "eth0")
+	}
+	request := newTCPPacket(t, "172.16.0.5", "93.184.216.34", 40000, 443, nil, []byte("request"))
+	response := newTCPPacket(t, "93.184.216.34", "172.16.0.5", 443, 40000, nil, []byte("response body"))
+	send(request)
+	send(response)
+	// 本地网络中其他主机的连接按方向统计，但不查找进程
+	send(newUDPPacket(t, "10.1.2.3", "8.8.8.8", 5353, 53, []byte("query")))
+
+	if m.lookups != 0 {
+		t.Fatalf("转发流量和本地网络中其他主机的连接不应查找进程，实际查找 %d 次", m.lookups)
+	}
+	transit := e.GetTransitStats()
+	if len(transit) != 1 {
+		t.Fatalf("转发流量两个方向应合并为 1 条记录，实际 %d", len(transit))
+	}
+	tr := transit[0]
+	if tr.Direction != DirectionTransit || !tr.LocalIP.Equal(net.ParseIP("93.184.216.34")) || tr.LocalPort != 443 {
+		t.Fatalf("转发记录应以地址较小的一端为 LocalIP，实际 %+v", tr)
+	}
+	if tr.BytesSent != uint64(len(response)) || tr.BytesReceived != uint64(len(request)) {
+		t.Fatalf("转发记录的收发字节数不匹配，实际 %d/%d", tr.BytesSent, tr.BytesReceived)
+	}
+	stats := e.GetTrafficStats()
+	if len(stats) != 1 || stats[0].Direction != DirectionOutbound || !stats[0].LocalIP.Equal(net.ParseIP("10.1.2.3")) {
+		t.Fatalf("本地网络的连接应为 1 条出站记录，实际 %+v", stats)
+	}
+}
+
+// 添加测试：两端都在本地网络的连接，两个方向的数据包合并为一条记录，并能识别连接关闭
+func TestLocalNetworkFlowMerged(t *testing.T) {
+	_, lan, _ := net.ParseCIDR("10.1.0.0/16")
+	e := NewEngine(WithProcessResolver(newMockResolver()), WithLocalIPs(net.ParseIP("192.168.1.10")), WithLocalNetworks(lan))
+	send := func(data []byte) {
+		e.processCapturedPacket(gopacket.NewPacket(data, layers.LinkTypeEthernet, gopacket.Default), "eth0")
+	}
+	// 客户端地址较大，应答方向为出站
+	request := newTCPPacket(t, "10.1.4.5", "10.1.2.3", 40000, 80, &layers.TCP{ACK: true, Seq: 100}, []byte("request"))
+	response := newTCPPacket(t, "10.1.2.3", "10.1.4.5", 80, 40000, &layers.TCP{ACK: true, Seq: 900}, []byte("response body"))
+	send(request)
+	send(response)
+	send(newTCPPacket(t, "10.1.4.5", "10.1.2.3", 40000, 80, &layers.TCP{FIN: true, ACK: true, Seq: 107}, nil))
+	send(newTCPPacket(t, "10.1.2.3", "10.1.4.5", 80, 40000, &layers.TCP{FIN: true, ACK: true, Seq: 913}, nil))
+
+	stats := e.GetTrafficStats()
+	if len(stats) != 1 {
+		t.Fatalf("两个方向的数据包应合并为 1 条记录，实际 %d", len(stats))
+	}
+	tr := stats[0]
+	if !tr.LocalIP.Equal(net.ParseIP("10.1.2.3")) || tr.LocalPort != 80 {
+		t.Fatalf("应以地址较小的一端为 LocalIP，实际 %+v", tr)
+	}
+	if tr.PacketsSent != 2 || tr.PacketsReceived != 2 || tr.BytesReceived < uint64(len(request)) || tr.BytesSent < uint64(len(response)) {
+		t.Fatalf("应答数据包应计入同一条记录，实际 收%d/发%d", tr.BytesReceived, tr.BytesSent)
+	}
+	if tr.TCPState != TCPStateClosed {
+		t.Fatalf("双方FIN后连接应为关闭状态，实际 %s", tr.TCPState)
+	}
+}
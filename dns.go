@@ -0,0 +1,93 @@
+package netguard
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// DNS记录的最短保留时间。TTL很短的记录在应答后往往才建立连接，按TTL立即过期会丢失域名
+const dnsMinTTL = 30 * time.Second
+
+// dnsCache 从抓到的DNS应答中得到的 IP -> 域名 缓存，按应答的TTL过期
+type dnsCache struct {
+	mu      sync.RWMutex
+	entries map[string]dnsEntry // key: IP string
+}
+
+type dnsEntry struct {
+	name   string
+	expire time.Time
+}
+
+func newDNSCache() *dnsCache {
+	return &dnsCache{entries: make(map[string]dnsEntry)}
+}
+
+// add 记录IP对应的域名。同一IP被多个域名解析到时，保留最近一次查询的域名
+func (c *dnsCache) add(ip net.IP, name string, ttl time.Duration, now time.Time) {
+	ttl = max(ttl, dnsMinTTL)
+	c.mu.Lock()
+	c.entries[ip.String()] = dnsEntry{name: name, expire: now.Add(ttl)}
+	c.mu.Unlock()
+}
+
+// lookup 查询IP对应的域名，没有或已过期时返回空字符串
+func (c *dnsCache) lookup(ip net.IP, now time.Time) string {
+	c.mu.RLock()
+	entry, ok := c.entries[ip.String()]
+	c.mu.RUnlock()
+	if !ok || now.After(entry.expire) {
+		return ""
+	}
+	return entry.name
+}
+
+// prune 删除过期的记录
+func (c *dnsCache) prune(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for ip, entry := range c.entries {
+		if now.After(entry.expire) {
+			delete(c.entries, ip)
+		}
+	}
+}
+
+// handleDNS 解析数据包中的DNS应答，把A/AAAA记录的IP与查询的域名加入缓存。
+// 经过CNAME跳转的应答也记为查询的域名，即用户实际访问的域名
+func (e *Engine) handleDNS(packet gopacket.Packet, now time.Time) {
+	if now.IsZero() {
+		now = time.Now()
+	}
+	dnsLayer, ok := packet.Layer(layers.LayerTypeDNS).(*layers.DNS)
+	if !ok || !dnsLayer.QR || dnsLayer.ResponseCode != layers.DNSResponseCodeNoErr {
+		return
+	}
+	var question string
+	if len(dnsLayer.Questions) > 0 {
+		question = string(dnsLayer.Questions[0].Name)
+	}
+	for _, answer := range dnsLayer.Answers {
+		if answer.Type != layers.DNSTypeA && answer.Type != layers.DNSTypeAAAA || answer.IP == nil {
+			continue
+		}
+		name := question
+		if name == "" {
+			name = string(answer.Name)
+		}
+		e.dnsCache.add(answer.IP, name, time.Duration(answer.TTL)*time.Second, now)
+	}
+}
+
+// LookupHostname 查询最近一次DNS应答中解析到该IP的域名。没有抓到对应的DNS应答或已过期时返回空字符串。
+// 离线回放的记录按文件中的抓包时间过期，这里不再判断是否过期
+func (e *Engine) LookupHostname(ip net.IP) string {
+	if e.isOffline() {
+		return e.dnsCache.lookup(ip, time.Time{})
+	}
+	return e.dnsCache.lookup(ip, time.Now())
+}
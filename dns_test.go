@@ -0,0 +1,90 @@
+package netguard
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// newDNSResponse 构造经过CNAME跳转的DNS应答数据包
+func newDNSResponse(t *testing.T, question, cname string, ip net.IP, ttl uint32) []byte {
+	t.Helper()
+	dns := &layers.DNS{
+		ID:           1,
+		QR:           true,
+		ResponseCode: layers.DNSResponseCodeNoErr,
+		Questions:    []layers.DNSQuestion{{Name: []byte(question), Type: layers.DNSTypeA, Class: layers.DNSClassIN}},
+		Answers: []layers.DNSResourceRecord{
+			{Name: []byte(question), Type: layers.DNSTypeCNAME, Class: layers.DNSClassIN, TTL: ttl, CNAME: []byte(cname)},
+			{Name: []byte(cname), Type: layers.DNSTypeA, Class: layers.DNSClassIN, TTL: ttl, IP: ip},
+		},
+	}
+	buf := gopacket.NewSerializeBuffer()
+	if err := dns.SerializeTo(buf, gopacket.SerializeOptions{FixLengths: true}); err != nil {
+		t.Fatalf("序列化DNS应答失败: %v", err)
+	}
+	return newUDPPacket(t, "8.8.8.8", "192.168.1.10", 53, 40000, buf.Bytes())
+}
+
+// 添加测试：从DNS应答中记录域名，之后的连接带上最初查询的域名
+func TestDNSHostname(t *testing.T) {
+	var msg string
+	e := NewEngine(WithLocalIPs(net.ParseIP("192.168.1.10")), WithPacketHook(func(info *TrafficRecord) {
+		msg = info.Msg
+	}))
+	send := func(data []byte) {
+		e.processCapturedPacket(gopacket.NewPacket(data, layers.LinkTypeEthernet, gopacket.Default), "")
+	}
+	send(newDNSResponse(t, "www.example.com", "edge.example.net", net.ParseIP("93.184.216.34"), 300))
+	send(newTCPPacket(t, "192.168.1.10", "93.184.216.34", 50000, 443, nil, []byte("request")))
+
+	var found bool
+	for _, tr := range e.GetTrafficStats() {
+		if tr.RemotePort != 443 {
+			continue
+		}
+		found = true
+		if tr.RemoteHost != "www.example.com" || !strings.Contains(msg, "www.example.com") {
+			t.Fatalf("连接应带上查询的域名，实际 %q %q", tr.RemoteHost, msg)
+		}
+	}
+	if !found {
+		t.Fatal("没有找到 HTTPS 连接的记录")
+	}
+	if host := e.LookupHostname(net.ParseIP("93.184.216.34")); host != "www.example.com" {
+		t.Fatalf("LookupHostname 应返回 www.example.com，实际 %q", host)
+	}
+	stats := e.GetRemoteHostStats(0)
+	for _, s := range stats {
+		if s.RemoteIP == "93.184.216.34" && s.Hostname != "www.example.com" {
+			t.Fatalf("按远程IP汇总应带上域名，实际 %+v", s)
+		}
+	}
+}
+
+// 添加测试：DNS缓存按TTL过期，TTL过短时至少保留 dnsMinTTL
+func TestDNSCacheExpire(t *testing.T) {
+	c := newDNSCache()
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	ip := net.ParseIP("1.1.1.1")
+	c.add(ip, "one.example.com", 5*time.Minute, now)
+	if name := c.lookup(ip, now.Add(4*time.Minute)); name != "one.example.com" {
+		t.Fatalf("TTL内应能查到域名，实际 %q", name)
+	}
+	if name := c.lookup(ip, now.Add(6*time.Minute)); name != "" {
+		t.Fatalf("超过TTL后应查不到域名，实际 %q", name)
+	}
+
+	c.add(ip, "short.example.com", 0, now)
+	if name := c.lookup(ip, now.Add(dnsMinTTL/2)); name != "short.example.com" {
+		t.Fatalf("TTL为0的记录应至少保留 %s，实际 %q", dnsMinTTL, name)
+	}
+	c.prune(now.Add(time.Hour))
+	if len(c.entries) != 0 {
+		t.Fatalf("过期记录应被清理，剩余 %d 条", len(c.entries))
+	}
+}
@@ -0,0 +1,189 @@
+package netguard
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Engine 网络流量监控引擎。
+// 持有自己的流量统计表、进程查找器、钩子函数和后台协程，同一进程内可创建多个互不干扰的实例。
+type Engine struct {
+	trafficMap    sync.Map     // 用于网络链接的流量统计 key: 网卡名+flowKey 五元组 string, value: *TrafficRecord
+	transitMap    sync.Map     // 转发流量的统计，两端都不是本机或本地网络。key 和 value 同 trafficMap
+	localIPs      []net.IP     // 缓存本地IP列表
+	localIPsMutex sync.RWMutex // 保护 localIPs 的并发访问
+	fixedLocalIPs bool         // 本地IP列表由 WithLocalIPs 指定，不再从网卡获取
+	localNets     []*net.IPNet // 本地网络，其中的地址也作为本地一端判断流量方向
+
+	classifier atomic.Pointer[AddressClassifier] // 远程地址分类，包含 WithInternalNetworks 设置的内部网络
+
+	realTimeProcessQuery bool                // 实时进程查询开关，仅对默认的 GopsutilResolver 有效
+	processResolver      ProcessResolver     // 查找连接所属的进程，默认为 GopsutilResolver
+	appLaunchers         map[string]struct{} // 启动器进程名（小写），用于确定顶层应用
+	processMetas         *processMetaCache   // 进程详细信息缓存，与 processResolver 读取同一个 procfs 目录
+
+	cleanInterval     time.Duration // trafficMap 过期记录的清理周期
+	closedFlowTimeout time.Duration // 已关闭的TCP连接保留多久后清理
+	hookPacket        func(info *TrafficRecord)
+	hookHTTPRequest   func(req *HTTPRequest)    // 从明文HTTP流量中解析出请求时调用
+	bpfFilter         string                    // 网卡抓包的BPF过滤器，为空时使用 DefaultBPFFilter
+	captureOpts       CaptureOptions            // 网卡抓包参数
+	openCapture       openCaptureFunc           // 按抓包参数打开网卡，默认为 openLive
+	captureLoopback   bool                      // 是否同时监控环回网卡
+	recorder          *PcapRecorder             // 不为 nil 时把网卡抓到的原始数据包录制到文件
+	geoLookup         func(ip string) GeoIpInfo // 查询远程IP的地理位置和ASN，用于按国家、ASN汇总
+	geoCache          sync.Map                  // 远程IP的地理位置缓存 key: IP string, value: GeoIpInfo
+	dnsCache          *dnsCache                 // 从DNS应答中得到的 IP -> 域名 缓存
+
+	runMutex  sync.Mutex
+	runCancel context.CancelFunc // 不为 nil 表示正在抓包，调用后停止本次抓包
+	runDone   chan struct{}      // 本次抓包完全结束后关闭
+	offline   bool               // 本次运行是否为离线文件回放。在启动worker之前设置
+}
+
+// Option 创建 Engine 时的可选配置项
+type Option func(e *Engine)
+
+// WithRealTimeProcessQuery 设置新建连接时，是否实时查询系统连接表以获取进程PID。默认开启。
+// 仅在未通过 WithProcessResolver 指定查找方式时有效。
+func WithRealTimeProcessQuery(enable bool) Option {
+	return func(e *Engine) {
+		e.realTimeProcessQuery = enable
+	}
+}
+
+// WithCleanInterval 设置流量记录的清理周期。超过该时长未更新的记录会被删除。默认10分钟。
+func WithCleanInterval(d time.Duration) Option {
+	return func(e *Engine) {
+		e.cleanInterval = d
+	}
+}
+
+// WithClosedFlowTimeout 设置TCP连接关闭（双方FIN或RST）后，记录保留多久再清理。默认30秒。
+func WithClosedFlowTimeout(d time.Duration) Option {
+	return func(e *Engine) {
+		e.closedFlowTimeout = d
+	}
+}
+
+// WithLocalIPs 指定本机IP列表，用于判断流量方向。
+// 离线回放其他主机的抓包文件时，需指定抓包主机的IP。设置后不再从本机网卡获取。
+func WithLocalIPs(ips ...net.IP) Option {
+	return func(e *Engine) {
+		e.localIPs = ips
+		e.fixedLocalIPs = true
+	}
+}
+
+// WithPacketHook 设置数据包钩子函数。每个数据包更新流量统计后都会调用。
+func WithPacketHook(packetHook func(info *TrafficRecord)) Option {
+	return func(e *Engine) {
+		e.hookPacket = packetHook
+	}
+}
+
+// WithRecorder 设置抓包录制器，把网卡抓到的原始数据包滚动保存为 pcapng 文件。离线回放时不录制。
+func WithRecorder(r *PcapRecorder) Option {
+	return func(e *Engine) {
+		e.recorder = r
+	}
+}
+
+// WithLoopback 设置是否同时监控环回网卡，统计本机进程之间（如访问 127.0.0.1）的流量。默认不监控。
+// 本机进程之间的连接在发送方和接收方各记录一条，分别归属各自的进程。
+func WithLoopback(enable bool) Option {
+	return func(e *Engine) {
+		e.captureLoopback = enable
+	}
+}
+
+// WithProcessResolver 设置查找连接所属进程的方式，默认为 NewGopsutilResolver。
+// Linux 下可使用 NewProcfsResolver 或 NewNetlinkResolver，避免轮询系统连接表
+func WithProcessResolver(r ProcessResolver) Option {
+	return func(e *Engine) {
+		e.processResolver = r
+	}
+}
+
+// WithGeoLookup 设置远程IP的地理位置查询函数。默认使用 GetIpGeo，GeoIP数据库文件不存在时不查询。
+func WithGeoLookup(lookup func(ip string) GeoIpInfo) Option {
+	return func(e *Engine) {
+		e.geoLookup = lookup
+	}
+}
+
+// NewEngine 创建网络流量监控引擎
+//
+//	e := netguard.NewEngine(netguard.WithCleanInterval(5*time.Minute))
+//	e.SetPacketHook(func(info *netguard.TrafficRecord) {
+//		fmt.Println(info.Msg)
+//	})
+//	e.Run("")
+func NewEngine(opts ...Option) *Engine {
+	e := &Engine{
+		realTimeProcessQuery: true,
+		cleanInterval:        10 * time.Minute,
+		closedFlowTimeout:    30 * time.Second,
+		captureOpts:          DefaultCaptureOptions(),
+		geoLookup:            defaultGeoLookup,
+		dnsCache:             newDNSCache(),
+		openCapture:          openLive,
+		appLaunchers:         launcherSet(DefaultAppLaunchers),
+	}
+	e.classifier.Store(NewAddressClassifier())
+	for _, opt := range opts {
+		opt(e)
+	}
+	if e.processResolver == nil {
+		e.processResolver = NewGopsutilResolver(e.realTimeProcessQuery)
+	}
+	e.processMetas = newProcessMetaCache(resolverProcfsRoot(e.processResolver))
+	// 初始化时获取本地IP
+	e.updateLocalIPs()
+	return e
+}
+
+// SetPacketHook 设置数据包钩子函数
+func (e *Engine) SetPacketHook(packetHook func(info *TrafficRecord)) {
+	e.hookPacket = packetHook
+}
+
+// SetRecorder 设置抓包录制器。需在开始抓包前设置，传入 nil 则不录制。
+func (e *Engine) SetRecorder(r *PcapRecorder) {
+	e.recorder = r
+}
+
+// SetLoopback 设置是否同时监控环回网卡。需在开始抓包前设置
+func (e *Engine) SetLoopback(enable bool) {
+	e.captureLoopback = enable
+}
+
+// Loopback 是否同时监控环回网卡
+func (e *Engine) Loopback() bool {
+	return e.captureLoopback
+}
+
+// SetProcessResolver 设置查找连接所属进程的方式。需在开始抓包前设置，传入 nil 则使用默认的 GopsutilResolver。
+func (e *Engine) SetProcessResolver(r ProcessResolver) {
+	if r == nil {
+		r = NewGopsutilResolver(e.realTimeProcessQuery)
+	}
+	e.processResolver = r
+	e.processMetas = newProcessMetaCache(resolverProcfsRoot(r))
+}
+
+var (
+	defaultEngine     *Engine
+	defaultEngineOnce sync.Once
+)
+
+// DefaultEngine 获取默认引擎。包级别的 Run, RunWithDevice, SetPacketHook 等函数都作用于该引擎。
+func DefaultEngine() *Engine {
+	defaultEngineOnce.Do(func() {
+		defaultEngine = NewEngine()
+	})
+	return defaultEngine
+}
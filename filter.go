@@ -0,0 +1,43 @@
+package netguard
+
+import (
+	"fmt"
+
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+)
+
+// DefaultBPFFilter 默认的BPF过滤器。引擎只统计TCP和UDP流量
+const DefaultBPFFilter = "tcp or udp"
+
+// WithBPFFilter 设置网卡抓包使用的BPF过滤器，语法同 tcpdump，如 "tcp port 443"。为空时使用 DefaultBPFFilter。
+func WithBPFFilter(filter string) Option {
+	return func(e *Engine) {
+		e.bpfFilter = filter
+	}
+}
+
+// SetBPFFilter 设置网卡抓包使用的BPF过滤器。需在开始抓包前设置，为空时使用 DefaultBPFFilter。
+// 过滤器在开始抓包时校验，无效时 RunContext 返回错误。
+func (e *Engine) SetBPFFilter(filter string) {
+	e.bpfFilter = filter
+}
+
+// BPFFilter 获取网卡抓包使用的BPF过滤器
+func (e *Engine) BPFFilter() string {
+	if e.bpfFilter == "" {
+		return DefaultBPFFilter
+	}
+	return e.bpfFilter
+}
+
+// ValidateBPFFilter 校验BPF过滤器的语法。无效时返回包含编译错误的说明
+func ValidateBPFFilter(filter string) error {
+	if filter == "" {
+		return nil
+	}
+	if _, err := pcap.CompileBPFFilter(layers.LinkTypeEthernet, DEFAULT_SNAP_LEN, filter); err != nil {
+		return fmt.Errorf("无效的BPF过滤器(%s): %w", filter, err)
+	}
+	return nil
+}
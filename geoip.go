@@ -2,6 +2,7 @@ package netguard
 
 import (
 	"net/netip"
+	"os"
 	"sync"
 
 	"github.com/iotames/netguard/log"
@@ -10,36 +11,62 @@ import (
 )
 
 var (
+	// geoipMutex 保护下面的数据库变量。统计时多个worker协程会并发查询
+	geoipMutex  sync.RWMutex
 	geoipdbFile = "GeoLite2-City.mmdb"
 	geoipDb     *maxminddb.Reader
-	once        sync.Once
+
+	asnDb *maxminddb.Reader // ASN数据库，可选。未设置时不解析ASN
 )
 
 func SetGeoipDb(file string) error {
-	var err error
+	db, err := maxminddb.Open(file)
+	geoipMutex.Lock()
+	defer geoipMutex.Unlock()
 	geoipdbFile = file
-	geoipDb, err = maxminddb.Open(geoipdbFile)
+	geoipDb = db
 	return err
 }
 
+// getGeoipDb 获取地理位置数据库，第一次调用时打开。文件不存在时 panic
 func getGeoipDb() *maxminddb.Reader {
-	var err error
-	once.Do(func() {
-		if geoipDb == nil {
-			geoipDb, err = maxminddb.Open(geoipdbFile)
-			if err != nil {
-				log.Error("error", err.Error())
-				panic(err)
-			}
+	geoipMutex.RLock()
+	db := geoipDb
+	geoipMutex.RUnlock()
+	if db != nil {
+		return db
+	}
+	geoipMutex.Lock()
+	defer geoipMutex.Unlock()
+	if geoipDb == nil {
+		var err error
+		geoipDb, err = maxminddb.Open(geoipdbFile)
+		if err != nil {
+			log.Error("error", err.Error())
+			panic(err)
 		}
-	})
+	}
 	return geoipDb
 }
 
+// SetAsnDb 设置ASN数据库，如 GeoLite2-ASN.mmdb
+func SetAsnDb(file string) error {
+	db, err := maxminddb.Open(file)
+	if err != nil {
+		return err
+	}
+	geoipMutex.Lock()
+	asnDb = db
+	geoipMutex.Unlock()
+	return nil
+}
+
 type GeoIpInfo struct {
 	CountryCode string
 	Country     string
 	City        string
+	ASN         uint   // 自治系统号。未设置ASN数据库时为0
+	ASOrg       string // 自治系统所属组织
 }
 
 func GetIpGeo(remoteIP string) GeoIpInfo {
@@ -84,5 +111,42 @@ func GetIpGeo(remoteIP string) GeoIpInfo {
 	// }
 	// fmt.Printf("CountryInfo: %+v\n", record.Country.Names)
 	// fmt.Printf("CityInfo: %+v\n", record.City.Names)
-	return GeoIpInfo{record.Country.ISOCode, record.Country.Names["zh-CN"], record.City.Names["zh-CN"]}
+	info := GeoIpInfo{CountryCode: record.Country.ISOCode, Country: record.Country.Names["zh-CN"], City: record.City.Names["zh-CN"]}
+	info.ASN, info.ASOrg = GetIpAsn(remoteIP)
+	return info
+}
+
+// GetIpAsn 查询IP所属的自治系统。未设置ASN数据库或查询失败时返回 0 和空字符串
+func GetIpAsn(remoteIP string) (uint, string) {
+	geoipMutex.RLock()
+	db := asnDb
+	geoipMutex.RUnlock()
+	if db == nil {
+		return 0, ""
+	}
+	ip, err := netip.ParseAddr(remoteIP)
+	if err != nil {
+		return 0, ""
+	}
+	var record struct {
+		Number       uint   `maxminddb:"autonomous_system_number"`
+		Organization string `maxminddb:"autonomous_system_organization"`
+	}
+	if err = db.Lookup(ip).Decode(&record); err != nil {
+		log.Warn("asn lookup fail", "remoteIP", remoteIP, "error", err.Error())
+		return 0, ""
+	}
+	return record.Number, record.Organization
+}
+
+// geoipDbAvailable 是否可以查询地理位置。GetIpGeo 在数据库文件不存在时会 panic
+func geoipDbAvailable() bool {
+	geoipMutex.RLock()
+	db, file := geoipDb, geoipdbFile
+	geoipMutex.RUnlock()
+	if db != nil {
+		return true
+	}
+	_, err := os.Stat(file)
+	return err == nil
 }
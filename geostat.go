@@ -0,0 +1,191 @@
+package netguard
+
+import (
+	"sort"
+	"strconv"
+	"time"
+)
+
+// RemoteHostStat 按远程IP汇总的流量统计
+type RemoteHostStat struct {
+	RemoteIP        string
+	Hostname        string    // DNS应答中最近一次解析到该IP的域名。没有抓到DNS应答时为空
+	Geo             GeoIpInfo // 内网IP或未配置GeoIP数据库时为空
+	BytesSent       uint64
+	BytesReceived   uint64
+	PacketsSent     uint64
+	PacketsReceived uint64
+	Rate            TrafficRate
+	FlowCount       int // 五元组连接数
+	ProcessCount    int // 与该IP通信的不同进程数，未识别的进程不计入
+	LastUpdate      time.Time
+}
+
+// GeoGroupStat 按国家或ASN汇总的流量统计
+type GeoGroupStat struct {
+	Key             string // 国家代码，或ASN号（如 "AS13335"）。无法识别时为空
+	Name            string // 国家名称，或ASN所属组织
+	BytesSent       uint64
+	BytesReceived   uint64
+	PacketsSent     uint64
+	PacketsReceived uint64
+	Rate            TrafficRate
+	FlowCount       int
+	RemoteHostCount int // 不同远程IP数
+	LastUpdate      time.Time
+}
+
+// GetRemoteHostStats 获取默认引擎按远程IP汇总的流量统计，见 Engine.GetRemoteHostStats
+func GetRemoteHostStats(topN int) []*RemoteHostStat {
+	return DefaultEngine().GetRemoteHostStats(topN)
+}
+
+// GetCountryStats 获取默认引擎按国家汇总的流量统计，见 Engine.GetCountryStats
+func GetCountryStats(topN int) []*GeoGroupStat {
+	return DefaultEngine().GetCountryStats(topN)
+}
+
+// GetAsnStats 获取默认引擎按ASN汇总的流量统计，见 Engine.GetAsnStats
+func GetAsnStats(topN int) []*GeoGroupStat {
+	return DefaultEngine().GetAsnStats(topN)
+}
+
+// GetRemoteHostStats 按远程IP汇总流量统计，按收发总字节数从大到小排序，只返回前 topN 条。topN <= 0 时返回全部。
+func (e *Engine) GetRemoteHostStats(topN int) []*RemoteHostStat {
+	hostMap := make(map[string]*RemoteHostStat)
+	procs := make(map[string]map[int32]struct{})
+	var stats []*RemoteHostStat
+	for _, tr := range e.GetTrafficStats() {
+		ip := tr.RemoteIP.String()
+		stat, ok := hostMap[ip]
+		if !ok {
+			stat = &RemoteHostStat{RemoteIP: ip, Geo: e.remoteGeo(ip)}
+			hostMap[ip] = stat
+			procs[ip] = make(map[int32]struct{})
+			stats = append(stats, stat)
+		}
+		stat.BytesSent += tr.BytesSent
+		stat.BytesReceived += tr.BytesReceived
+		stat.PacketsSent += tr.PacketsSent
+		stat.PacketsReceived += tr.PacketsReceived
+		stat.Rate.add(tr.Rate)
+		stat.FlowCount++
+		if tr.ProcessPID > 0 {
+			procs[ip][tr.ProcessPID] = struct{}{}
+		}
+		// 使用最近更新的连接的域名
+		if tr.RemoteHost != "" && (stat.Hostname == "" || !tr.LastUpdate.Before(stat.LastUpdate)) {
+			stat.Hostname = tr.RemoteHost
+		}
+		if tr.LastUpdate.After(stat.LastUpdate) {
+			stat.LastUpdate = tr.LastUpdate
+		}
+	}
+	for ip, stat := range hostMap {
+		stat.ProcessCount = len(procs[ip])
+	}
+	sort.SliceStable(stats, func(i, j int) bool {
+		return stats[i].BytesSent+stats[i].BytesReceived > stats[j].BytesSent+stats[j].BytesReceived
+	})
+	if topN > 0 && len(stats) > topN {
+		stats = stats[:topN]
+	}
+	return stats
+}
+
+// GetCountryStats 按远程IP所属国家汇总流量统计，按收发总字节数从大到小排序，只返回前 topN 条。topN <= 0 时返回全部。
+// 内网IP和无法识别国家的IP汇总为 Key 为空的一条。
+func (e *Engine) GetCountryStats(topN int) []*GeoGroupStat {
+	return e.geoGroupStats(topN, func(geo GeoIpInfo) (string, string) {
+		return geo.CountryCode, geo.Country
+	})
+}
+
+// GetAsnStats 按远程IP所属自治系统汇总流量统计，按收发总字节数从大到小排序，只返回前 topN 条。topN <= 0 时返回全部。
+// 需要通过 SetAsnDb 设置ASN数据库，否则所有流量汇总为 Key 为空的一条。
+func (e *Engine) GetAsnStats(topN int) []*GeoGroupStat {
+	return e.geoGroupStats(topN, func(geo GeoIpInfo) (string, string) {
+		if geo.ASN == 0 {
+			return "", ""
+		}
+		return "AS" + strconv.FormatUint(uint64(geo.ASN), 10), geo.ASOrg
+	})
+}
+
+// geoGroupStats 在按远程IP汇总的基础上，按 groupKey 返回的键再次汇总
+func (e *Engine) geoGroupStats(topN int, groupKey func(geo GeoIpInfo) (key, name string)) []*GeoGroupStat {
+	groupMap := make(map[string]*GeoGroupStat)
+	var stats []*GeoGroupStat
+	for _, host := range e.GetRemoteHostStats(0) {
+		key, name := groupKey(host.Geo)
+		stat, ok := groupMap[key]
+		if !ok {
+			stat = &GeoGroupStat{Key: key, Name: name}
+			groupMap[key] = stat
+			stats = append(stats, stat)
+		}
+		stat.BytesSent += host.BytesSent
+		stat.BytesReceived += host.BytesReceived
+		stat.PacketsSent += host.PacketsSent
+		stat.PacketsReceived += host.PacketsReceived
+		stat.Rate.add(host.Rate)
+		stat.FlowCount += host.FlowCount
+		stat.RemoteHostCount++
+		if host.LastUpdate.After(stat.LastUpdate) {
+			stat.LastUpdate = host.LastUpdate
+		}
+	}
+	sort.SliceStable(stats, func(i, j int) bool {
+		return stats[i].BytesSent+stats[i].BytesReceived > stats[j].BytesSent+stats[j].BytesReceived
+	})
+	if topN > 0 && len(stats) > topN {
+		stats = stats[:topN]
+	}
+	return stats
+}
+
+// GetRemoteGeo 查询远程IP的地理位置和ASN。结果在引擎内缓存，IP的所有连接都被清理后缓存随之删除。
+// 内网IP不查询，返回空值。
+func (e *Engine) GetRemoteGeo(ip string) GeoIpInfo {
+	return e.remoteGeo(ip)
+}
+
+func (e *Engine) remoteGeo(ip string) GeoIpInfo {
+	if v, ok := e.geoCache.Load(ip); ok {
+		return v.(GeoIpInfo)
+	}
+	var info GeoIpInfo
+	if !e.IsNativeIP(ip) && e.geoLookup != nil {
+		info = e.geoLookup(ip)
+	}
+	e.geoCache.Store(ip, info)
+	return info
+}
+
+// pruneGeoCache 删除已没有连接的远程IP的地理位置缓存
+func (e *Engine) pruneGeoCache() {
+	active := make(map[string]struct{})
+	e.trafficMap.Range(func(key, value interface{}) bool {
+		if record, ok := value.(*TrafficRecord); ok {
+			// RemoteIP 创建后不再修改，无需加锁
+			active[record.RemoteIP.String()] = struct{}{}
+		}
+		return true
+	})
+	e.geoCache.Range(func(key, value interface{}) bool {
+		if _, ok := active[key.(string)]; !ok {
+			e.geoCache.Delete(key)
+		}
+		return true
+	})
+}
+
+// defaultGeoLookup 默认的地理位置查询。GeoIP数据库文件不存在时只查询ASN
+func defaultGeoLookup(ip string) GeoIpInfo {
+	if !geoipDbAvailable() {
+		var info GeoIpInfo
+		info.ASN, info.ASOrg = GetIpAsn(ip)
+		return info
+	}
+	return GetIpGeo(ip)
+}
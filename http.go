@@ -0,0 +1,132 @@
+package netguard
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// HTTPRequest 从明文HTTP流量中解析出的请求。创建后不再修改，可直接共享
+type HTTPRequest struct {
+	Method        string
+	URL           string      // 完整URL，如 http://example.com/index.html。没有Host头时为请求行中的路径
+	Path          string      // 请求行中的路径，含查询参数
+	Proto         string      // 协议版本，如 HTTP/1.1
+	Host          string      // Host 头
+	UserAgent     string      // User-Agent 头
+	Referer       string      // Referer 头
+	XForwardedFor string      // X-Forwarded-For 头
+	Headers       http.Header // 所有请求头。数据包被截断时只包含已抓到的部分
+	ClientIP      net.IP      // 发送请求的一端
+	ClientPort    uint16
+	ServerIP      net.IP
+	ServerPort    uint16
+	Interface     string // 抓到该请求的网卡名。离线回放时为空
+	ProcessName   string // 连接所属的本机进程，本机以外的连接为空
+	ProcessPID    int32
+	Time          time.Time // 抓包时间
+}
+
+// httpMethods 识别为HTTP请求的方法，后面跟一个空格
+var httpMethods = []string{"GET ", "POST ", "PUT ", "DELETE ", "HEAD ", "OPTIONS ", "PATCH ", "CONNECT ", "TRACE "}
+
+// parseHTTPRequest 解析以HTTP/1.x请求行开头的TCP载荷。不是HTTP请求时返回 false。
+// 请求头超过抓包长度或跨多个TCP段时，只解析已抓到的完整行
+func parseHTTPRequest(payload []byte) (*HTTPRequest, bool) {
+	if len(payload) == 0 || payload[0] < 'A' || payload[0] > 'Z' {
+		return nil, false
+	}
+	var isMethod bool
+	for _, m := range httpMethods {
+		if bytes.HasPrefix(payload, []byte(m)) {
+			isMethod = true
+			break
+		}
+	}
+	if !isMethod {
+		return nil, false
+	}
+	lineEnd := bytes.Index(payload, []byte("\r\n"))
+	if lineEnd < 0 {
+		return nil, false
+	}
+	parts := strings.Split(string(payload[:lineEnd]), " ")
+	if len(parts) != 3 || parts[1] == "" || !strings.HasPrefix(parts[2], "HTTP/1.") {
+		return nil, false
+	}
+	req := &HTTPRequest{Method: parts[0], Path: parts[1], Proto: parts[2], Headers: make(http.Header)}
+
+	rest := payload[lineEnd+2:]
+	for {
+		end := bytes.Index(rest, []byte("\r\n"))
+		if end <= 0 {
+			// 空行表示请求头结束；没有换行符表示被截断，最后一行不完整
+			break
+		}
+		line := string(rest[:end])
+		rest = rest[end+2:]
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		req.Headers.Add(textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(name)), strings.TrimSpace(value))
+	}
+	req.Host = req.Headers.Get("Host")
+	req.UserAgent = req.Headers.Get("User-Agent")
+	req.Referer = req.Headers.Get("Referer")
+	req.XForwardedFor = req.Headers.Get("X-Forwarded-For")
+
+	switch {
+	case req.Method == "CONNECT" || strings.HasPrefix(req.Path, "http://"):
+		// 代理请求：请求行中已是完整的地址
+		req.URL = req.Path
+	case req.Host != "":
+		req.URL = "http://" + req.Host + req.Path
+	default:
+		req.URL = req.Path
+	}
+	return req, true
+}
+
+// updateHTTP 在TCP载荷中查找明文HTTP请求，找到时记录为连接最近一个请求并返回。调用方需持有写锁。
+// 已识别为TLS的连接不再查找。本机进程之间的通信，同一数据包会更新发送方和接收方两条记录，只在发送方的记录中解析，避免重复记录
+func (tr *TrafficRecord) updateHTTP(p *packetInfo, now time.Time) *HTTPRequest {
+	if p.localPeer && p.inbound || tr.TLS.Version != "" || len(p.tcp.Payload) == 0 {
+		return nil
+	}
+	req, ok := parseHTTPRequest(p.tcp.Payload)
+	if !ok {
+		return nil
+	}
+	// 入站数据包由远程一端发出，远程一端为客户端
+	req.ClientIP, req.ClientPort, req.ServerIP, req.ServerPort = tr.LocalIP, tr.LocalPort, tr.RemoteIP, tr.RemotePort
+	if p.inbound {
+		req.ClientIP, req.ClientPort, req.ServerIP, req.ServerPort = tr.RemoteIP, tr.RemotePort, tr.LocalIP, tr.LocalPort
+	}
+	req.Interface = tr.Interface
+	req.ProcessName, req.ProcessPID = tr.ProcessName, tr.ProcessPID
+	req.Time = now
+	tr.HTTP = req
+	tr.HTTPRequests++
+	return req
+}
+
+// WithHTTPRequestHook 设置HTTP请求钩子函数。从明文HTTP流量中解析出请求时调用，如保存到数据库。
+func WithHTTPRequestHook(hook func(req *HTTPRequest)) Option {
+	return func(e *Engine) {
+		e.hookHTTPRequest = hook
+	}
+}
+
+// SetHTTPRequestHook 设置HTTP请求钩子函数
+func (e *Engine) SetHTTPRequestHook(hook func(req *HTTPRequest)) {
+	e.hookHTTPRequest = hook
+}
+
+// SetHTTPRequestHook 设置默认引擎的HTTP请求钩子函数
+func SetHTTPRequestHook(hook func(req *HTTPRequest)) {
+	DefaultEngine().SetHTTPRequestHook(hook)
+}
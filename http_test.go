@@ -0,0 +1,97 @@
+package netguard
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// 添加测试：解析HTTP请求行和请求头，截断的最后一行忽略
+func TestParseHTTPRequest(t *testing.T) {
+	payload := []byte("GET /index.html?a=1 HTTP/1.1\r\nHost: www.example.com\r\nuser-agent: curl/8.0\r\nReferer: http://a.example.com/\r\nX-Forwarded-For: 10.0.0.1\r\n\r\nbody")
+	req, ok := parseHTTPRequest(payload)
+	if !ok {
+		t.Fatal("应识别为HTTP请求")
+	}
+	if req.Method != "GET" || req.Path != "/index.html?a=1" || req.Proto != "HTTP/1.1" || req.URL != "http://www.example.com/index.html?a=1" {
+		t.Fatalf("请求行解析不正确: %+v", req)
+	}
+	if req.Host != "www.example.com" || req.UserAgent != "curl/8.0" || req.Referer != "http://a.example.com/" || req.XForwardedFor != "10.0.0.1" {
+		t.Fatalf("请求头解析不正确: %+v", req)
+	}
+
+	// 代理请求的请求行为完整地址；截断的最后一行不完整，忽略
+	req, ok = parseHTTPRequest([]byte("POST http://proxy.example.com/api HTTP/1.0\r\nHost: proxy.example.com\r\nUser-Agent: trunc"))
+	if !ok || req.URL != "http://proxy.example.com/api" || req.UserAgent != "" {
+		t.Fatalf("代理请求解析不正确: %v %+v", ok, req)
+	}
+
+	for _, payload := range []string{"HTTP/1.1 200 OK\r\n", "GET /index.html", "GETX / HTTP/1.1\r\n", "GET / SPDY/3\r\n", "\x16\x03\x01"} {
+		if _, ok := parseHTTPRequest([]byte(payload)); ok {
+			t.Fatalf("%q 不应识别为HTTP请求", payload)
+		}
+	}
+}
+
+// 添加测试：入站连接中的HTTP请求以远程一端为客户端，重传的请求不重复记录
+func TestHTTPRequestHook(t *testing.T) {
+	var reqs []*HTTPRequest
+	e := NewEngine(WithProcessResolver(newMockResolver()), WithLocalIPs(net.ParseIP("192.168.1.10")),
+		WithHTTPRequestHook(func(req *HTTPRequest) { reqs = append(reqs, req) }))
+	send := func(data []byte) {
+		e.processCapturedPacket(gopacket.NewPacket(data, layers.LinkTypeEthernet, gopacket.Default), "")
+	}
+	request := newTCPPacket(t, "93.184.216.34", "192.168.1.10", 50000, 80, &layers.TCP{ACK: true, Seq: 100},
+		[]byte("GET / HTTP/1.1\r\nHost: 192.168.1.10\r\nUser-Agent: Mozilla/5.0\r\n\r\n"))
+	send(request)
+	send(newTCPPacket(t, "192.168.1.10", "93.184.216.34", 80, 50000, &layers.TCP{ACK: true, Seq: 900}, []byte("HTTP/1.1 200 OK\r\n\r\n")))
+	send(request)
+
+	if len(reqs) != 1 {
+		t.Fatalf("应记录 1 个请求，实际 %d", len(reqs))
+	}
+	req := reqs[0]
+	if !req.ClientIP.Equal(net.ParseIP("93.184.216.34")) || req.ClientPort != 50000 || !req.ServerIP.Equal(net.ParseIP("192.168.1.10")) || req.ServerPort != 80 {
+		t.Fatalf("入站请求的客户端应为远程一端，实际 %+v", req)
+	}
+	if req.URL != "http://192.168.1.10/" || req.UserAgent != "Mozilla/5.0" {
+		t.Fatalf("请求解析不正确: %+v", req)
+	}
+	stats := e.GetTrafficStats()
+	if len(stats) != 1 || stats[0].HTTP != req || stats[0].HTTPRequests != 1 {
+		t.Fatalf("连接应记录最近一个HTTP请求，实际 %+v", stats)
+	}
+}
+
+// 添加测试：本机进程之间的HTTP请求会更新两条记录，但每个请求只记录一次
+func TestHTTPRequestLoopback(t *testing.T) {
+	var reqs []*HTTPRequest
+	e := NewEngine(WithProcessResolver(newMockResolver()), WithLocalIPs(net.ParseIP("192.168.1.10")),
+		WithHTTPRequestHook(func(req *HTTPRequest) { reqs = append(reqs, req) }))
+	send := func(data []byte) {
+		e.processCapturedPacket(gopacket.NewPacket(data, layers.LinkTypeEthernet, gopacket.Default), "lo")
+	}
+	send(newTCPPacket(t, "127.0.0.1", "127.0.0.1", 50000, 8080, &layers.TCP{ACK: true, Seq: 100},
+		[]byte("GET /a HTTP/1.1\r\nHost: 127.0.0.1:8080\r\n\r\n")))
+	send(newTCPPacket(t, "127.0.0.1", "127.0.0.1", 8080, 50000, &layers.TCP{ACK: true, Seq: 900}, []byte("HTTP/1.1 200 OK\r\n\r\n")))
+	send(newTCPPacket(t, "127.0.0.1", "127.0.0.1", 50000, 8080, &layers.TCP{ACK: true, Seq: 141},
+		[]byte("GET /b HTTP/1.1\r\nHost: 127.0.0.1:8080\r\n\r\n")))
+
+	if len(reqs) != 2 || reqs[0].Path != "/a" || reqs[1].Path != "/b" {
+		t.Fatalf("每个请求应只记录 1 次，实际 %d 次", len(reqs))
+	}
+	for _, req := range reqs {
+		if req.ClientPort != 50000 || req.ServerPort != 8080 {
+			t.Fatalf("请求的客户端应为发送方，实际 %+v", req)
+		}
+	}
+	var total uint64
+	for _, tr := range e.GetTrafficStats() {
+		total += tr.HTTPRequests
+	}
+	if total != 2 {
+		t.Fatalf("两条记录合计应有 2 个请求，实际 %d", total)
+	}
+}
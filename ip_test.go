@@ -0,0 +1,146 @@
+package netguard
+
+import (
+	"net"
+	"testing"
+)
+
+// 添加测试：IPv4和IPv6的本地地址段分类
+func TestIsNativeIP(t *testing.T) {
+	cases := []struct {
+		ip     string
+		native bool
+	}{
+		// IPv4 私有、环回、链路本地
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"172.31.255.255", true},
+		{"172.32.0.1", false},
+		{"192.168.1.1", true},
+		{"127.0.0.1", true},
+		{"169.254.10.1", true},
+		{"0.0.0.0", true},
+		// IPv4 运营商级NAT、文档地址、组播、保留地址
+		{"100.64.0.1", true},
+		{"100.127.255.255", true},
+		{"100.128.0.1", false},
+		{"192.0.2.10", true},
+		{"198.51.100.10", true},
+		{"203.0.113.10", true},
+		{"224.0.0.251", true},
+		{"239.255.255.250", true},
+		{"255.255.255.255", true},
+		// IPv4 公网
+		{"8.8.8.8", false},
+		{"93.184.216.34", false},
+		{"::ffff:192.168.1.1", true},
+		{"::ffff:8.8.8.8", false},
+		// IPv6 特殊地址段
+		{"::", true},
+		{"::1", true},
+		{"fe80::1", true},
+		{"febf::1", true},
+		{"fc00::1", true},
+		{"fd12:3456::1", true},
+		{"ff02::fb", true},
+		{"2001:db8::1", true},
+		{"3fff::1", true},
+		{"100::1", true},
+		// IPv6 公网
+		{"2001:4860:4860::8888", false},
+		{"2606:4700:4700::1111", false},
+		// 无法解析的字符串视为本地地址
+		{"not an ip", true},
+		{"", true},
+	}
+	for _, c := range cases {
+		if got := IsNativeIP(c.ip); got != c.native {
+			t.Errorf("IsNativeIP(%q) = %v，期望 %v", c.ip, got, c.native)
+		}
+	}
+}
+
+// 添加测试：前缀树按最长前缀匹配，IPv4和IPv6互不影响
+func TestPrefixTrie(t *testing.T) {
+	var trie prefixTrie
+	for _, c := range []struct {
+		cidr  string
+		class AddressClass
+	}{
+		{"10.0.0.0/8", AddressPrivate},
+		{"10.8.0.0/16", AddressInternal},
+		{"10.8.1.1/32", AddressLoopback},
+		{"fd00::/8", AddressPrivate},
+		// IPv4映射的IPv6前缀按IPv4前缀 172.16.0.0/12 插入
+		{"::ffff:172.16.0.0/108", AddressPrivate},
+	} {
+		_, n, _ := net.ParseCIDR(c.cidr)
+		trie.insert(n, c.class)
+	}
+	cases := []struct {
+		ip    string
+		class AddressClass
+		found bool
+	}{
+		{"10.1.2.3", AddressPrivate, true},
+		{"10.8.2.3", AddressInternal, true},
+		{"10.8.1.1", AddressLoopback, true},
+		{"::ffff:10.8.2.3", AddressInternal, true},
+		{"11.0.0.1", AddressPublic, false},
+		{"172.20.0.1", AddressPrivate, true},
+		{"172.32.0.1", AddressPublic, false},
+		{"fd12::1", AddressPrivate, true},
+		{"fe80::1", AddressPublic, false},
+	}
+	for _, c := range cases {
+		class, found := trie.lookup(net.ParseIP(c.ip))
+		if class != c.class || found != c.found {
+			t.Errorf("lookup(%s) = %s/%v，期望 %s/%v", c.ip, class, found, c.class, c.found)
+		}
+	}
+}
+
+// 添加测试：自定义内部网络的地址分类，以及对 IsNativeIP 和流量记录的影响。内部网络只作用于设置它的引擎
+func TestInternalNetworks(t *testing.T) {
+	cases := map[string]AddressClass{
+		"192.168.1.1":   AddressPrivate,
+		"127.0.0.1":     AddressLoopback,
+		"ff02::fb":      AddressMulticast,
+		"2001:db8::1":   AddressReserved,
+		"93.184.216.34": AddressPublic,
+	}
+	for ip, class := range cases {
+		if got := ClassifyAddress(net.ParseIP(ip)); got != class {
+			t.Errorf("ClassifyAddress(%s) = %s，期望 %s", ip, got, class)
+		}
+	}
+
+	_, vpn, _ := net.ParseCIDR("10.8.0.0/16")
+	_, office, _ := net.ParseCIDR("93.184.216.0/24")
+	e := NewEngine(WithInternalNetworks(vpn, office))
+	if got := e.ClassifyAddress(net.ParseIP("10.8.3.4")); got != AddressInternal {
+		t.Fatalf("VPN网段应为内部地址，实际 %s", got)
+	}
+	if got := e.ClassifyAddress(net.ParseIP("10.9.3.4")); got != AddressPrivate {
+		t.Fatalf("VPN网段以外的私有地址仍为私有，实际 %s", got)
+	}
+	if !e.IsNativeIP("93.184.216.34") || e.IsNativeIP("93.184.217.1") {
+		t.Fatal("办公室的公网网段应视为本地地址，网段以外仍为公网地址")
+	}
+	if ClassifyAddress(net.ParseIP("10.8.3.4")) != AddressPrivate || IsNativeIP("93.184.216.34") {
+		t.Fatal("其他引擎的内部网络不应影响默认引擎")
+	}
+
+	e.updatePacketRecord(&packetInfo{
+		localIP:    net.IPv4(192, 168, 1, 10),
+		localPort:  50000,
+		remoteIP:   net.IPv4(93, 184, 216, 34),
+		remotePort: 443,
+		protocol:   "TCP",
+		length:     100,
+		pid:        1,
+	})
+	if tr := e.GetTrafficStats()[0]; tr.RemoteClass != AddressInternal {
+		t.Fatalf("流量记录的远程地址分类应为内部，实际 %s", tr.RemoteClass)
+	}
+}
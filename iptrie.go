@@ -0,0 +1,83 @@
+package netguard
+
+import (
+	"net"
+
+	"github.com/iotames/netguard/log"
+)
+
+// prefixTrie 按二进制位存储IP前缀的前缀树，用于最长前缀匹配。IPv4和IPv6分别使用一棵树
+type prefixTrie struct {
+	v4, v6 *trieNode
+}
+
+type trieNode struct {
+	child [2]*trieNode
+	class AddressClass
+	set   bool // 该节点是否为插入的前缀
+}
+
+// ipBits 返回IP按字节的表示，IPv4为4字节，IPv6为16字节
+func ipBits(ip net.IP) []byte {
+	if v4 := ip.To4(); v4 != nil {
+		return v4
+	}
+	return ip.To16()
+}
+
+// root 返回地址长度对应的树根，需要时创建
+func (t *prefixTrie) root(addr []byte, create bool) *trieNode {
+	p := &t.v6
+	if len(addr) == net.IPv4len {
+		p = &t.v4
+	}
+	if *p == nil && create {
+		*p = &trieNode{}
+	}
+	return *p
+}
+
+// insert 插入前缀。相同的前缀后插入的覆盖先插入的
+func (t *prefixTrie) insert(n *net.IPNet, class AddressClass) {
+	addr := ipBits(n.IP)
+	ones, bits := n.Mask.Size()
+	// IPv4映射的IPv6前缀（如 ::ffff:10.0.0.0/104）按IPv4前缀插入，与查找时的处理一致
+	if len(addr) == net.IPv4len && bits == 8*net.IPv6len && ones >= 96 {
+		ones, bits = ones-96, 8*net.IPv4len
+	}
+	if addr == nil || bits != len(addr)*8 {
+		log.Warn("忽略无效的地址段", "地址段", n.String())
+		return
+	}
+	node := t.root(addr, true)
+	for i := 0; i < ones; i++ {
+		b := addr[i/8] >> (7 - i%8) & 1
+		if node.child[b] == nil {
+			node.child[b] = &trieNode{}
+		}
+		node = node.child[b]
+	}
+	node.class = class
+	node.set = true
+}
+
+// lookup 查找包含IP的最长前缀
+func (t *prefixTrie) lookup(ip net.IP) (AddressClass, bool) {
+	addr := ipBits(ip)
+	if addr == nil {
+		return 0, false
+	}
+	node := t.root(addr, false)
+	var class AddressClass
+	var found bool
+	for i := 0; node != nil; i++ {
+		if node.set {
+			class, found = node.class, true
+		}
+		if i == len(addr)*8 {
+			break
+		}
+		node = node.child[addr[i/8]>>(7-i%8)&1]
+	}
+	return class, found
+}
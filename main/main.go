@@ -7,6 +7,7 @@ import (
 
 	"github.com/iotames/netguard"
 	"github.com/iotames/netguard/conf"
+	"github.com/iotames/netguard/log"
 	"github.com/iotames/netguard/webserver"
 )
 
@@ -20,6 +21,10 @@ func main() {
 		showDevices()
 		return
 	}
+	if ReadFile != "" {
+		runNetguardFile()
+		return
+	}
 
 	if runtime.GOOS == "windows" && IsPathExists("amis.html") && Port > 0 {
 		go func() {
@@ -52,6 +57,26 @@ func runNetguard() {
 	netguard.DebugRun(Devname)
 }
 
+// runNetguardFile 回放离线抓包文件
+func runNetguardFile() {
+	f := setLog()
+	defer f.Close()
+
+	setGeoipDb()
+	var opts []netguard.Option
+	if ips := parseLocalIPs(LocalIPs); len(ips) > 0 {
+		opts = append(opts, netguard.WithLocalIPs(ips...))
+	}
+	if nets := parseCIDRs(LocalNets); len(nets) > 0 {
+		opts = append(opts, netguard.WithLocalNetworks(nets...))
+	}
+	err := netguard.NewEngine(opts...).DebugRunWithFile(ReadFile)
+	if err != nil {
+		log.Error("回放抓包文件失败", "error", err.Error(), "readfile", ReadFile)
+		panic(err)
+	}
+}
+
 func init() {
 	err := conf.LoadEnv()
 	if err != nil {
@@ -63,4 +88,9 @@ func init() {
 	parseArgs()
 	initScript()
 	dbinit()
+	setPcapRecorder()
+	setProcessResolver()
+	setBpfFilter()
+	setCaptureOptions()
+	setInternalNetworks()
 }
@@ -6,13 +6,25 @@ import (
 	"github.com/iotames/netguard/conf"
 )
 
-var Devname string
+var Devname, ReadFile, LocalIPs, LocalNets, Filter string
 var ListDev, V, VersionV bool
 var Port int
+var SnapLen, BufferMB, TimeoutMS int
+var Promisc, Immediate, Loopback bool
 
 func parseArgs() {
-	flag.StringVar(&Devname, "devname", "", `netguard.exe --devname="\Device\NPF_{3757BF1E-96B9-441B-8D4B-95EAB49ECA36}"`)
+	flag.StringVar(&Devname, "devname", "", `多个网卡用逗号分隔，all表示所有非环回网卡: netguard.exe --devname="\Device\NPF_{3757BF1E-96B9-441B-8D4B-95EAB49ECA36}"`)
 	flag.BoolVar(&ListDev, "listdev", false, "netguard.exe --listdev")
+	flag.StringVar(&ReadFile, "readfile", "", "回放离线抓包文件(.pcap/.pcapng): netguard.exe --readfile=capture.pcapng")
+	flag.StringVar(&LocalIPs, "localips", "", "回放抓包文件时，抓包主机的IP列表，用于判断流量方向: netguard.exe --readfile=capture.pcapng --localips=192.168.1.10,fe80::1")
+	flag.StringVar(&LocalNets, "localnets", conf.LocalNetworks, "本地网络(CIDR)列表，用于区分入站、出站和转发流量: netguard.exe --localnets=192.168.1.0/24,fd00::/64")
+	flag.StringVar(&Filter, "filter", conf.BpfFilter, `网卡抓包的BPF过滤器: netguard.exe --filter="tcp port 443 or udp port 53"`)
+	flag.IntVar(&SnapLen, "snaplen", conf.CaptureSnapLen, "每个数据包最多捕获的字节数: netguard.exe --snaplen=1600")
+	flag.BoolVar(&Promisc, "promisc", conf.CapturePromisc, "是否开启混杂模式: netguard.exe --promisc=false")
+	flag.IntVar(&BufferMB, "buffermb", conf.CaptureBufferMB, "内核抓包缓冲区大小(MB)，0表示系统默认值: netguard.exe --buffermb=64")
+	flag.IntVar(&TimeoutMS, "timeoutms", conf.CaptureTimeoutMS, "抓包读超时(毫秒)，0表示无限期等待: netguard.exe --timeoutms=500")
+	flag.BoolVar(&Immediate, "immediate", conf.CaptureImmediate, "是否开启立即模式: netguard.exe --immediate")
+	flag.BoolVar(&Loopback, "loopback", conf.CaptureLoopback, "是否同时监控环回网卡: netguard.exe --loopback")
 	flag.IntVar(&Port, "port", conf.WebServerPort, "netguard.exe --port=8080")
 	flag.BoolVar(&V, "v", false, "netguard.exe --v")
 	flag.BoolVar(&VersionV, "version", false, "netguard.exe --version")
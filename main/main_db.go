@@ -2,6 +2,7 @@ package main
 
 import (
 	"database/sql"
+	"fmt"
 	"time"
 
 	"github.com/iotames/easydb"
@@ -22,6 +23,10 @@ func dbinit() {
 	if err != nil {
 		panic(err)
 	}
+	err = migrateTables()
+	if err != nil {
+		panic(err)
+	}
 	db.SetDb(edb)
 	log.Info("数据库初始化完成", "DbDriver", conf.DbDriver, "DbHost", conf.DbHost, "DbPort", conf.DbPort, "DbName", conf.DbName)
 
@@ -34,6 +39,47 @@ func createTables() (sql.Result, error) {
 	return execSqlFile("sqlite_init.sql")
 }
 
+// migrateTables 为旧版本创建的表补充新增的列。CREATE TABLE IF NOT EXISTS 不会修改已存在的表
+func migrateTables() error {
+	err := addColumnIfNotExists("ng_hook_logs", "container_id", "VARCHAR(64)")
+	if err != nil {
+		return err
+	}
+	_, err = edb.Exec("CREATE INDEX IF NOT EXISTS idx_logs_container ON ng_hook_logs(container_id)")
+	if err != nil {
+		return err
+	}
+	return addColumnIfNotExists("ng_hook_logs", "remote_host", "VARCHAR(255)")
+}
+
+// addColumnIfNotExists 表中没有该列时添加
+func addColumnIfNotExists(table, column, definition string) error {
+	rows, err := edb.GetSqlDB().Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cid, notnull, pk int
+		var name, ctype string
+		var dflt sql.NullString
+		if err = rows.Scan(&cid, &name, &ctype, &notnull, &dflt, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	_, err = edb.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err == nil {
+		log.Info("数据表新增列", "table", table, "column", column)
+	}
+	return err
+}
+
 func execSqlFile(filename string, args ...any) (sql.Result, error) {
 	var err error
 	var sqltxt string
@@ -3,7 +3,10 @@ package main
 import (
 	"fmt"
 	"log/slog"
+	"net"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/iotames/netguard"
 	"github.com/iotames/netguard/conf"
@@ -33,6 +36,13 @@ func setGeoipDb() {
 		log.Error("set geoip db fail", "error", err.Error(), "geoipFile", geoipFile)
 		panic(err)
 	}
+	// ASN数据库可选，存在时才按ASN汇总流量
+	asnFile := "GeoLite2-ASN.mmdb"
+	if _, err = os.Stat(asnFile); err == nil {
+		if err = netguard.SetAsnDb(asnFile); err != nil {
+			log.Warn("set asn db fail", "error", err.Error(), "asnFile", asnFile)
+		}
+	}
 }
 
 func showDevices() {
@@ -42,7 +52,99 @@ func showDevices() {
 	}
 }
 
+// parseLocalIPs 解析逗号分隔的IP列表，忽略无法解析的项
+func parseLocalIPs(s string) []net.IP {
+	var ips []net.IP
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		ip := net.ParseIP(v)
+		if ip == nil {
+			log.Warn("无效的IP地址，已忽略", "ip", v)
+			continue
+		}
+		ips = append(ips, ip)
+	}
+	return ips
+}
+
+// parseCIDRs 解析逗号分隔的CIDR列表，忽略无法解析的项
+func parseCIDRs(s string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			log.Warn("无效的CIDR，已忽略", "cidr", v)
+			continue
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+// setPcapRecorder 按配置开启抓包录制
+func setPcapRecorder() {
+	if !conf.PcapRecord {
+		return
+	}
+	r := netguard.NewPcapRecorder(netguard.RecordOptions{
+		Dir:            conf.GetPcapRecordDir(),
+		MaxFileSize:    int64(conf.PcapRecordMaxSizeMB) * 1024 * 1024,
+		RotateInterval: time.Duration(conf.PcapRecordRotateMinutes) * time.Minute,
+		MaxFiles:       conf.PcapRecordMaxFiles,
+	})
+	netguard.DefaultEngine().SetRecorder(r)
+	log.Info("已开启抓包录制", "目录", conf.GetPcapRecordDir())
+}
+
 func initScript() {
 	sqldir := hotswap.NewScriptDir(sql.GetSqlFs(), conf.ScriptsDir)
 	hotswap.GetScriptDir(sqldir)
 }
+
+// setProcessResolver 按配置设置查找连接所属进程的方式
+func setProcessResolver() {
+	r, err := netguard.NewProcessResolver(conf.ProcessResolver)
+	if err != nil {
+		log.Error("设置进程查找方式失败，使用默认方式", "error", err.Error(), "PROCESS_RESOLVER", conf.ProcessResolver)
+		return
+	}
+	netguard.DefaultEngine().SetProcessResolver(r)
+}
+
+// setBpfFilter 设置网卡抓包的BPF过滤器。命令行参数优先于配置文件。
+// 过滤器无效时 RunContext 会返回错误，这里提前校验以便尽早提示
+func setBpfFilter() {
+	if err := netguard.ValidateBPFFilter(Filter); err != nil {
+		log.Error("BPF过滤器无效", "error", err.Error(), "filter", Filter)
+		panic(err)
+	}
+	netguard.DefaultEngine().SetBPFFilter(Filter)
+}
+
+// setCaptureOptions 设置网卡抓包参数、是否监控环回网卡和本地网络。命令行参数优先于配置文件
+func setCaptureOptions() {
+	netguard.DefaultEngine().SetCaptureOptions(netguard.CaptureOptions{
+		SnapLen:       SnapLen,
+		Promiscuous:   Promisc,
+		BufferSize:    BufferMB * 1024 * 1024,
+		Timeout:       time.Duration(TimeoutMS) * time.Millisecond,
+		ImmediateMode: Immediate,
+	})
+	netguard.DefaultEngine().SetLoopback(Loopback)
+	netguard.DefaultEngine().SetLocalNetworks(parseCIDRs(LocalNets)...)
+}
+
+// setInternalNetworks 按配置设置内部网络
+func setInternalNetworks() {
+	if nets := parseCIDRs(conf.InternalCIDRs); len(nets) > 0 {
+		netguard.SetInternalNetworks(nets...)
+		log.Info("已设置内部网络", "INTERNAL_CIDRS", conf.InternalCIDRs)
+	}
+}
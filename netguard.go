@@ -1,14 +1,17 @@
 package netguard
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"runtime"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/google/gopacket"
-	"github.com/google/gopacket/pcap"
+	"github.com/google/gopacket/layers"
 	"github.com/iotames/netguard/device"
 	"github.com/iotames/netguard/log"
 )
@@ -16,124 +19,485 @@ import (
 // TrafficRecord 记录流量信息
 type TrafficRecord struct {
 	sync.RWMutex
-	LocalIP         net.IP
-	LocalPort       uint16
-	RemoteIP        net.IP
-	RemotePort      uint16
-	Protocol        string
-	ProcessName     string
-	ProcessPID      int32
-	BytesCurrentLen uint64
-	BytesSent       uint64
-	BytesReceived   uint64
-	Inbound         bool
-	Msg             string
-	LastUpdate      time.Time
-	LastLogTime     time.Time
-}
-
-// 全局变量
-// TODO 注意全局变量字典的内存空间占用
-var (
-	trafficMap    sync.Map     // 用于网络链接的流量统计 key: "LocalIP:LocalPort" string, value: *TrafficRecord
-	connectionMap sync.Map     // 网络连接与进程的映射关系 key: "IP:Port" string, value: int32 (PID)
-	localIPs      []net.IP     // 缓存本地IP列表
-	localIPsMutex sync.RWMutex // 新增：保护 localIPs 的并发访问
-)
+	LocalIP          net.IP
+	LocalPort        uint16
+	RemoteIP         net.IP
+	RemotePort       uint16
+	Protocol         string
+	ProcessName      string
+	ProcessPID       int32
+	ProcessExe       string            // 进程的可执行文件路径
+	ProcessCmdline   string            // 进程的命令行
+	ProcessUser      string            // 进程所属用户名
+	ProcessPPID      int32             // 父进程PID
+	ProcessStartTime time.Time         // 进程启动时间
+	ParentChain      []ProcessAncestor // 父进程链，从直接父进程到最上层。创建后不再修改，可直接共享
+	AppPID           int32             // 顶层应用的PID，如浏览器渲染进程所属的浏览器主进程。进程本身即为顶层应用时等于 ProcessPID
+	AppName          string            // 顶层应用的进程名
+	ContainerID      string            // 进程所在容器的ID，不在容器中时为空
+	Interface        string            // 抓到该连接的网卡名。离线回放时为空
+	LocalPeer        bool              // 对端也是本机的进程，如访问 127.0.0.1。这类流量在发送方和接收方各记录一条连接
+	Direction        Direction         // 最近一个数据包的方向。转发流量的记录始终为 DirectionTransit
+	RemoteClass      AddressClass      // 远程IP的地址分类，如私有地址、公司内部网络、公网地址
+	RemoteHost       string            // 远程IP对应的域名，取自抓到的DNS应答中最近一次查询的域名。没有抓到时为空
+	BytesCurrentLen  uint64
+	BytesSent        uint64
+	BytesReceived    uint64
+	PacketsSent      uint64
+	PacketsReceived  uint64
+	Inbound          bool
+	Msg              string
+	FirstSeen        time.Time // 第一个数据包的时间
+	LastUpdate       time.Time
+	LastLogTime      time.Time
+	Rate             TrafficRate // 最近一个数据包时刻的速率。GetTrafficStats 返回的副本为获取时刻的速率
+	rates            ewmaRates
+	lastResolve      time.Time // 最近一次查找进程的时间，进程未识别时用于控制重试间隔
 
-func init() {
-	// 初始化时获取本地IP
-	updateLocalIPs()
-	// 定期清理长时间未更新的trafficMap记录
-	go cleanTrafficMap(0)
-	// 定期清理进程查询缓存
-	go cleanupProcessCache()
+	// 以下为TCP连接的信息，UDP连接为零值
+	TCPState        TCPState     // 连接状态
+	ConnStartTime   time.Time    // 连接开始时间：看到SYN的时间，抓包开始前已建立的连接为第一个数据包的时间
+	ConnEndTime     time.Time    // 连接结束时间：双方FIN或RST的时间
+	Retransmissions uint64       // 重传的数据包数
+	OutOfOrder      uint64       // 乱序到达的数据包数
+	TLS             TLSInfo      // 连接第一段载荷中TLS ClientHello的信息，非TLS连接或抓包开始前已建立的连接为零值
+	HTTP            *HTTPRequest // 最近一个明文HTTP请求，没有时为 nil。创建后不再修改，可直接共享
+	HTTPRequests    uint64       // 明文HTTP请求数
+	tcp             tcpTracker
 }
 
+// AllDevices 作为网卡名时，表示监控所有有IP地址的非环回网卡
+const AllDevices = "all"
+
+// Run 使用默认引擎开始监控。devName 为空时自动选择默认网卡。
 func Run(devName string) {
+	DefaultEngine().Run(devName)
+}
+
+// Start 使用默认引擎在后台开始监控，见 Engine.Start
+func Start(ctx context.Context, devName string) error {
+	return DefaultEngine().Start(ctx, devName)
+}
+
+// RunWithDevice 使用默认引擎监控指定网卡
+func RunWithDevice(devName string) {
+	DefaultEngine().RunWithDevice(devName)
+}
+
+// RunContext 使用默认引擎监控指定网卡，ctx 取消后停止抓包并返回
+func RunContext(ctx context.Context, devName string) error {
+	return DefaultEngine().RunContext(ctx, devName)
+}
+
+// RunDevices 使用默认引擎同时监控多个网卡，ctx 取消后停止抓包并返回
+func RunDevices(ctx context.Context, devNames []string) error {
+	return DefaultEngine().RunDevices(ctx, devNames)
+}
+
+// Stop 停止默认引擎的抓包
+func Stop() {
+	DefaultEngine().Stop()
+}
+
+// DebugRun 使用默认引擎开始监控，并把流量概要输出到控制台和日志
+func DebugRun(devName string) {
+	DefaultEngine().DebugRun(devName)
+}
+
+// Run 开始监控。devName 为空时自动选择默认网卡。
+func (e *Engine) Run(devName string) {
 	fmt.Println("Run Start. devName=", devName)
 	log.Info("Run Start", "devName", devName)
 	if devName != "" {
-		RunWithDevice(devName)
+		e.RunWithDevice(devName)
 		return
 	}
 	fmt.Println("devname未定义。开始获取默认的devname。可使用 --devlist 查看所有可用设备。使用 --devname 指定设备")
-	// 获取网络接口列表并选择（这里选择第一个非环回接口为例）
-	dev := device.GetDefaultDevice()
-	if dev.Name == "" {
+	devName, err := getDefaultDevName()
+	if err != nil {
 		log.Error("未找到可用网络设备，退出监控")
 		return
 	}
-	log.Info("开始监控：", "设备", dev.Name, "详情", dev.Description)
-	RunWithDevice(dev.Name)
+	e.RunWithDevice(devName)
 }
 
-func RunWithDevice(devName string) {
-	// 1. 打开设备进行捕获
-	// devName 要监控的网络接口
-	// 1600 每个数据包最多捕获 1600 字节（略大于标准 MTU 1500 字节）
-	// true 开启混杂模式，捕获所有经过网卡的数据包
-	// pcap.BlockForever 超时时间。无限期等待数据包，不设置超时
-	handle, err := pcap.OpenLive(devName, 1600, true, pcap.BlockForever)
+// RunWithDevice 监控指定网卡。会一直阻塞直到抓包结束。
+func (e *Engine) RunWithDevice(devName string) {
+	err := e.RunContext(context.Background(), devName)
 	if err != nil {
-		log.Error("打开设备失败:", "错误", err)
 		panic(err)
 	}
-	defer handle.Close()
+}
+
+// RunContext 监控指定网卡，devName 为空时自动选择默认网卡。
+// 多个网卡用逗号分隔，AllDevices 表示所有非环回网卡，见 RunDevices。
+// 会一直阻塞，直到 ctx 被取消或调用 Stop。
+// 停止时关闭抓包句柄，处理完已入队的数据包，并等待所有后台协程退出后才返回。
+// 只有启动失败时才返回错误，包括BPF过滤器无效。
+func (e *Engine) RunContext(ctx context.Context, devName string) error {
+	return e.RunDevices(ctx, strings.Split(devName, ","))
+}
+
+// RunDevices 同时监控多个网卡，每条流量记录的 Interface 为抓到该连接的网卡。
+// devNames 为空时自动选择默认网卡，包含 AllDevices 时监控所有有IP地址的非环回网卡。
+// 开启 WithLoopback 时同时监控环回网卡。
+// 任一网卡打开失败时返回错误，不会只监控部分网卡。阻塞和停止的行为同 RunContext。
+func (e *Engine) RunDevices(ctx context.Context, devNames []string) error {
+	run, err := e.startDevices(ctx, devNames)
+	if err != nil {
+		return err
+	}
+	run()
+	return nil
+}
 
-	// 可设置BPF过滤器，例如 "tcp or udp"
-	err = handle.SetBPFFilter("tcp or udp")
+// Start 打开网卡后在后台抓包，不阻塞。网卡参数同 RunContext。
+// 打开网卡或设置过滤器失败时返回错误；返回 nil 时引擎已在运行，IsRunning 为 true，调用 Stop 停止。
+func (e *Engine) Start(ctx context.Context, devName string) error {
+	run, err := e.startDevices(ctx, strings.Split(devName, ","))
 	if err != nil {
-		log.Warn("设置过滤器失败（继续执行）: ", "错误", err)
+		return err
+	}
+	go run()
+	return nil
+}
+
+// startDevices 登记本次运行并打开所有网卡。返回的 run 开始抓包，阻塞直到抓包结束。
+// 返回错误时已关闭打开的网卡并取消登记
+func (e *Engine) startDevices(ctx context.Context, devNames []string) (run func(), err error) {
+	// 先校验过滤器，避免打开设备后才发现语法错误
+	filter := e.BPFFilter()
+	err = ValidateBPFFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	devNames, err = resolveDevNames(devNames)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	done, err := e.beginRun(cancel, false)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	var sources []captureSource
+	stop := func() {
+		for _, src := range sources {
+			src.reader.Close()
+		}
+		e.endRun(done)
+		cancel()
+	}
+	if e.captureLoopback {
+		devNames = appendLoopbackDevNames(devNames)
+	}
+	for _, devName := range devNames {
+		handle, err := e.openDevice(devName, filter)
+		if err != nil {
+			stop()
+			return nil, err
+		}
+		sources = append(sources, captureSource{iface: devName, reader: handle})
+		log.Info("开始监控：", "设备", devName)
+	}
+	return func() {
+		defer stop()
+		e.capture(ctx, sources...)
+	}, nil
+}
+
+// openDevice 按抓包参数打开网卡并设置BPF过滤器
+func (e *Engine) openDevice(devName, filter string) (captureHandle, error) {
+	// 1. 打开设备进行捕获
+	// 抓包长度、混杂模式、缓冲区大小、超时等参数见 CaptureOptions
+	opts := e.captureOpts
+	handle, err := e.openCapture(devName, opts)
+	if err != nil {
+		log.Error("打开设备失败:", "错误", err, "设备", devName, "抓包参数", fmt.Sprintf("%+v", opts))
+		return nil, fmt.Errorf("打开设备(%s)失败: %w", devName, err)
+	}
+
+	// 2. 设置BPF过滤器，例如 "tcp or udp"。网卡的链路类型不是以太网时，校验通过的过滤器仍可能设置失败
+	if err = handle.SetBPFFilter(filter); err != nil {
+		handle.Close()
+		log.Error("设置过滤器失败:", "错误", err, "设备", devName, "过滤器", filter)
+		return nil, fmt.Errorf("设置网卡(%s)的BPF过滤器(%s)失败: %w", devName, filter, err)
+	}
+	log.Info("已设置BPF过滤器", "设备", devName, "过滤器", filter)
+	return handle, nil
+}
+
+// Stop 停止正在运行的抓包，并等待 RunContext 返回。引擎未运行时直接返回。
+func (e *Engine) Stop() {
+	e.runMutex.Lock()
+	cancel, done := e.runCancel, e.runDone
+	e.runMutex.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+// isOffline 当前或最近一次运行是否为离线文件回放
+func (e *Engine) isOffline() bool {
+	e.runMutex.Lock()
+	defer e.runMutex.Unlock()
+	return e.offline
+}
+
+// IsRunning 引擎是否正在抓包
+func (e *Engine) IsRunning() bool {
+	e.runMutex.Lock()
+	defer e.runMutex.Unlock()
+	return e.runCancel != nil
+}
+
+// beginRun 登记本次运行。同一引擎同时只能运行一次抓包。
+// offline 表示数据包来自离线抓包文件。
+func (e *Engine) beginRun(cancel context.CancelFunc, offline bool) (chan struct{}, error) {
+	e.runMutex.Lock()
+	defer e.runMutex.Unlock()
+	if e.runCancel != nil {
+		return nil, fmt.Errorf("引擎正在运行，请先停止")
 	}
+	e.offline = offline
+	e.runCancel = cancel
+	e.runDone = make(chan struct{})
+	return e.runDone, nil
+}
+
+func (e *Engine) endRun(done chan struct{}) {
+	e.runMutex.Lock()
+	e.runCancel = nil
+	e.runDone = nil
+	e.runMutex.Unlock()
+	close(done)
+}
+
+// packetReader 数据包来源。网卡抓包句柄 *pcap.Handle 或离线抓包文件
+type packetReader interface {
+	gopacket.PacketDataSource
+	LinkType() layers.LinkType
+	Close()
+}
+
+// captureSource 一个数据包来源，以及抓包的网卡名。离线回放时网卡名为空
+type captureSource struct {
+	iface  string
+	reader packetReader
+}
 
-	// 2. 定期更新进程连接映射表（因为进程连接会动态变化）
-	go updateProcessConnectionMap()
-	// 定期更新本地IP
-	go periodicallyUpdateLocalIPs()
+// capturedPacket 交给worker处理的数据包
+type capturedPacket struct {
+	packet gopacket.Packet
+	iface  string
+}
 
-	// 3. 创建数据包源并开始处理
-	packetSource := gopacket.NewPacketSource(handle, handle.LinkType())
+// capture 从所有数据包来源读取数据包并分发给worker池处理，直到全部读完或 ctx 被取消
+func (e *Engine) capture(ctx context.Context, sources ...captureSource) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var bgwg sync.WaitGroup
+	background := []func(ctx context.Context){
+		// 定期更新本地IP
+		e.periodicallyUpdateLocalIPs,
+	}
+	if !e.offline {
+		// 定期清理长时间未更新的trafficMap记录。离线回放使用文件中的时间，不按当前时间清理
+		background = append(background, e.cleanTrafficMap)
+		if r, ok := e.processResolver.(backgroundResolver); ok {
+			// 2. 进程查找器的后台任务，如定期更新进程连接映射表（因为进程连接会动态变化）
+			background = append(background, r.Run)
+		}
+	}
+	for _, fn := range background {
+		bgwg.Add(1)
+		go func() {
+			defer bgwg.Done()
+			fn(ctx)
+		}()
+	}
+
+	// ctx 取消时关闭句柄，packetSource 读到 EOF 后会关闭 Packets() 通道，从而结束下面的抓包循环
+	stopped := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			for _, src := range sources {
+				src.reader.Close()
+			}
+		case <-stopped:
+		}
+	}()
+
+	// 3. 创建worker池并开始处理
 	numCPU := runtime.NumCPU()
 	workerPoolNum := numCPU * 2
 	log.Info("开始处理数据包：", "CPU核心数", numCPU, "工作池数", workerPoolNum)
 
-	// 创建worker池
-	packetChan := make(chan gopacket.Packet, 1000) // 缓冲队列
-	for i := 0; i < workerPoolNum; i++ {
+	// 创建worker池。每个worker有自己的缓冲队列，同一连接的数据包总是交给同一个worker，保证按抓包顺序处理
+	var wg sync.WaitGroup
+	queueSize := max(1000/workerPoolNum, 64)
+	packetChans := make([]chan capturedPacket, workerPoolNum)
+	for i := range packetChans {
+		packetChan := make(chan capturedPacket, queueSize) // 缓冲队列
+		packetChans[i] = packetChan
+		wg.Add(1)
 		go func() {
-			for packet := range packetChan {
-				processCapturedPacket(packet)
+			defer wg.Done()
+			for p := range packetChan {
+				e.processCapturedPacket(p.packet, p.iface)
 			}
 		}()
 	}
 
+	// 录制网卡抓到的原始数据包
+	recorder := e.recorder
+	if e.offline {
+		recorder = nil
+	}
+	if recorder != nil {
+		defer recorder.Close()
+	}
+
+	// 每个数据包来源一个读取协程，全部读完后才关闭worker的队列
+	var rwg sync.WaitGroup
+	for _, src := range sources {
+		rwg.Add(1)
+		go func() {
+			defer rwg.Done()
+			e.readPackets(src, packetChans, recorder)
+		}()
+	}
+	rwg.Wait()
+	close(stopped)
+
+	// 抓包结束后关闭 channel，等待 worker 处理完剩余的数据包后退出
+	for _, packetChan := range packetChans {
+		close(packetChan)
+	}
+	wg.Wait()
+	// 停止后台定时任务
+	cancel()
+	bgwg.Wait()
+	log.Info("抓包结束")
+}
+
+// readPackets 从一个数据包来源读取数据包并分发给worker，直到读完或句柄被关闭
+func (e *Engine) readPackets(src captureSource, packetChans []chan capturedPacket, recorder *PcapRecorder) {
+	handle := src.reader
+	var decoder gopacket.Decoder = handle.LinkType()
+	if d, ok := handle.(gopacket.Decoder); ok {
+		// 离线抓包文件中不同网卡的数据包，链路类型可能不同
+		decoder = d
+	}
+	packetSource := gopacket.NewPacketSource(handle, decoder)
 	// 在packetSource循环中发送到channel，使用非阻塞发送以防阻塞捕获循环
 	for packet := range packetSource.Packets() {
+		if recorder != nil {
+			if err := recorder.WriteInterfacePacket(src.iface, handle.LinkType(), packet.Metadata().CaptureInfo, packet.Data()); err != nil {
+				log.Warn("录制数据包失败", "错误", err)
+			}
+		}
+		packetChan := packetChans[flowHash(packet)%uint64(len(packetChans))]
+		p := capturedPacket{packet: packet, iface: src.iface}
+		if e.offline {
+			// 离线回放不会丢失数据包，处理不过来时等待即可
+			packetChan <- p
+			continue
+		}
 		select {
-		case packetChan <- packet:
+		case packetChan <- p:
 			// 正常入队
 		default:
 			// 缓冲区满，丢包并记录少量调试信息以便排查
 			srcIP, dstIP, protocol, ok := getPacketNetworkInfo(packet)
 			if ok {
-				log.Error("packetChan 满，丢弃一个数据包", "srcIP", srcIP, "dstIP", dstIP, "protocol", protocol)
+				log.Error("packetChan 满，丢弃一个数据包", "srcIP", srcIP, "dstIP", dstIP, "protocol", protocol, "设备", src.iface)
 			}
 		}
 	}
+}
 
-	// 抓包结束后关闭 channel，让 worker 退出
-	close(packetChan)
+// getDefaultDevName 获取默认的网卡名称（第一个非环回接口）
+func getDefaultDevName() (string, error) {
+	dev := device.GetDefaultDevice()
+	if dev.Name == "" {
+		return "", fmt.Errorf("未找到可用网络设备")
+	}
+	log.Info("开始监控：", "设备", dev.Name, "详情", dev.Description)
+	return dev.Name, nil
 }
 
-func DebugRun(devName string) {
+// appendLoopbackDevNames 把环回网卡加入监控列表，已在列表中的不重复添加
+func appendLoopbackDevNames(devNames []string) []string {
+	for _, dev := range device.GetLoopbackDevices() {
+		if !slices.Contains(devNames, dev.Name) {
+			devNames = append(devNames, dev.Name)
+		}
+	}
+	return devNames
+}
+
+// resolveDevNames 去掉空白和重复的网卡名。为空时使用默认网卡，包含 AllDevices 时展开为所有非环回网卡
+func resolveDevNames(devNames []string) ([]string, error) {
+	var names []string
+	seen := make(map[string]struct{})
+	add := func(name string) {
+		if _, ok := seen[name]; !ok {
+			seen[name] = struct{}{}
+			names = append(names, name)
+		}
+	}
+	for _, name := range devNames {
+		name = strings.TrimSpace(name)
+		switch name {
+		case "":
+		case AllDevices:
+			for _, dev := range device.GetNonLoopbackDevices() {
+				add(dev.Name)
+			}
+		default:
+			add(name)
+		}
+	}
+	if len(names) > 0 {
+		return names, nil
+	}
+	if slices.Contains(devNames, AllDevices) {
+		return nil, fmt.Errorf("未找到可用网络设备")
+	}
+	name, err := getDefaultDevName()
+	if err != nil {
+		return nil, err
+	}
+	return []string{name}, nil
+}
+
+// DebugRun 开始监控，并把流量概要输出到控制台和日志
+func (e *Engine) DebugRun(devName string) {
+	e.setDebugHook()
+	e.Run(devName)
+}
+
+// DebugRunWithFile 回放离线抓包文件，并把流量概要输出到控制台和日志
+func (e *Engine) DebugRunWithFile(filename string) error {
+	e.setDebugHook()
+	return e.RunWithFile(context.Background(), filename)
+}
+
+// setDebugHook 设置输出流量概要和IP解析结果的钩子函数
+func (e *Engine) setDebugHook() {
 	// 使用sync.Map替代map，避免出现concurrent map writes错误
 	var ipinfomap = &sync.Map{}
 
-	SetPacketHook(func(info *TrafficRecord) {
+	e.SetPacketHook(func(info *TrafficRecord) {
 		remoteIp := info.RemoteIP.String()
 		// 跳过本地IP的处理
-		if IsNativeIP(remoteIp) {
+		if e.IsNativeIP(remoteIp) {
 			return
 		}
 		// 使用sync.Map的方法
@@ -152,5 +516,4 @@ func DebugRun(devName string) {
 			log.Info("PacketHook", "logmsg", logmsg)
 		}
 	})
-	Run(devName)
 }
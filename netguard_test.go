@@ -1,9 +1,13 @@
 package netguard
 
 import (
-	"fmt"
+	"context"
 	"net"
+	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
@@ -50,17 +54,61 @@ func TestGetPacketNetworkInfoIPv4(t *testing.T) {
 
 // 添加测试：设置 localIPs 并校验 isLocalIP 行为
 func TestIsLocalIP(t *testing.T) {
+	e := NewEngine()
 	// 设置 localIPs（需加锁）
-	localIPsMutex.Lock()
-	localIPs = []net.IP{net.IPv4(192, 168, 0, 2)}
-	localIPsMutex.Unlock()
+	e.localIPsMutex.Lock()
+	e.localIPs = []net.IP{net.IPv4(192, 168, 0, 2)}
+	e.localIPsMutex.Unlock()
 
-	if !isLocalIP(net.IPv4(192, 168, 0, 2)) {
+	if !e.isLocalIP(net.IPv4(192, 168, 0, 2)) {
 		t.Fatal("isLocalIP 对本地 IP 应返回 true")
 	}
-	if isLocalIP(net.IPv4(8, 8, 8, 8)) {
+	if e.isLocalIP(net.IPv4(8, 8, 8, 8)) {
 		t.Fatal("isLocalIP 对非本地 IP 应返回 false")
 	}
+	if !e.isLocalIP(net.IPv4(127, 0, 0, 1)) || !e.isLocalIP(net.IPv6loopback) {
+		t.Fatal("isLocalIP 对环回地址应返回 true")
+	}
+}
+
+// 添加测试：本机进程之间的数据包在发送方和接收方各记录一条连接，分别归属各自的进程
+func TestLocalPeerAttribution(t *testing.T) {
+	m := newMockResolver()
+	loopback := net.IPv4(127, 0, 0, 1)
+	m.set("TCP", loopback, 50000, ProcessInfo{PID: 100, Name: "curl"})
+	m.set("TCP", loopback, 8080, ProcessInfo{PID: 200, Name: "nginx"})
+	e := NewEngine(WithProcessResolver(m), WithLocalIPs(net.ParseIP("192.168.1.10")))
+
+	request := newTCPPacket(t, "127.0.0.1", "127.0.0.1", 50000, 8080, nil, []byte("GET /"))
+	response := newTCPPacket(t, "127.0.0.1", "127.0.0.1", 8080, 50000, nil, []byte("HTTP/1.1 200 OK"))
+	for _, data := range [][]byte{request, response} {
+		e.processCapturedPacket(gopacket.NewPacket(data, layers.LinkTypeEthernet, gopacket.Default), "lo")
+	}
+
+	stats := e.GetTrafficStats()
+	if len(stats) != 2 {
+		t.Fatalf("应有客户端和服务端 2 条记录，实际 %d", len(stats))
+	}
+	byPort := make(map[uint16]*TrafficRecord)
+	for _, tr := range stats {
+		if !tr.LocalPeer || tr.Interface != "lo" {
+			t.Fatalf("记录应标记为本机对端并带上网卡名，实际 %+v", tr)
+		}
+		byPort[tr.LocalPort] = tr
+	}
+	client, server := byPort[50000], byPort[8080]
+	if client == nil || client.ProcessPID != 100 || client.RemotePort != 8080 {
+		t.Fatalf("客户端记录应归属 curl，实际 %+v", client)
+	}
+	if server == nil || server.ProcessPID != 200 || server.RemotePort != 50000 {
+		t.Fatalf("服务端记录应归属 nginx，实际 %+v", server)
+	}
+	if client.BytesSent != uint64(len(request)) || client.BytesReceived != uint64(len(response)) {
+		t.Fatalf("客户端收发字节数不匹配，实际 %d/%d", client.BytesSent, client.BytesReceived)
+	}
+	if server.BytesSent != uint64(len(response)) || server.BytesReceived != uint64(len(request)) {
+		t.Fatalf("服务端收发字节数不匹配，实际 %d/%d", server.BytesSent, server.BytesReceived)
+	}
 }
 
 // 添加测试：调用 updatePacketRecord 并验证 trafficMap 中的记录
@@ -74,13 +122,21 @@ func TestUpdatePacketRecordCreatesRecord(t *testing.T) {
 	pid := int32(12345)
 	traffic := uint64(500)
 
-	key := fmt.Sprintf("%s:%d", localIP.String(), localPort)
-	// 先清理可能存在的旧记录
-	trafficMap.Delete(key)
+	key := flowKey(protocol, localIP, localPort, remoteIP, remotePort)
+	e := NewEngine()
 
-	updatePacketRecord(localIP, localPort, remoteIP, remotePort, protocol, processName, pid, traffic, false)
+	e.updatePacketRecord(&packetInfo{
+		localIP:     localIP,
+		localPort:   localPort,
+		remoteIP:    remoteIP,
+		remotePort:  remotePort,
+		protocol:    protocol,
+		processName: processName,
+		pid:         pid,
+		length:      traffic,
+	})
 
-	v, ok := trafficMap.Load(key)
+	v, ok := e.trafficMap.Load(key)
 	if !ok {
 		t.Fatalf("更新后未在 trafficMap 中找到 key=%s 的记录", key)
 	}
@@ -99,3 +155,288 @@ func TestUpdatePacketRecordCreatesRecord(t *testing.T) {
 		t.Fatalf("BytesSent 不匹配，期望 %d，实际 %d", traffic, tr.BytesSent)
 	}
 }
+
+// 添加测试：不同引擎实例的流量表互不影响
+func TestEnginesAreIsolated(t *testing.T) {
+	e1 := NewEngine()
+	e2 := NewEngine()
+	var hooked int
+	e1.SetPacketHook(func(info *TrafficRecord) {
+		hooked++
+	})
+
+	e1.updatePacketRecord(&packetInfo{localIP: net.IPv4(10, 0, 0, 5), localPort: 40000, remoteIP: net.IPv4(1, 1, 1, 1), remotePort: 443, protocol: "TCP", length: 100})
+
+	if len(e1.GetTrafficStats()) != 1 {
+		t.Fatalf("e1 应有 1 条流量记录，实际 %d", len(e1.GetTrafficStats()))
+	}
+	if len(e2.GetTrafficStats()) != 0 {
+		t.Fatalf("e2 不应有流量记录，实际 %d", len(e2.GetTrafficStats()))
+	}
+	if hooked != 1 {
+		t.Fatalf("e1 的钩子函数应被调用 1 次，实际 %d", hooked)
+	}
+}
+
+// 添加测试：打开不存在的网卡时 RunContext 返回错误，且引擎不处于运行状态
+func TestRunContextOpenFail(t *testing.T) {
+	e := NewEngine()
+	err := e.RunContext(context.Background(), "netguard-no-such-device")
+	if err == nil {
+		t.Fatal("打开不存在的网卡应返回错误")
+	}
+	if e.IsRunning() {
+		t.Fatal("启动失败后引擎不应处于运行状态")
+	}
+	// 未运行时 Stop 应立即返回
+	e.Stop()
+}
+
+// 添加测试：同一个UDP套接字与多个对端通信时，按五元组分别统计，并可按套接字汇总
+func TestFlowKeyedByFiveTuple(t *testing.T) {
+	e := NewEngine(WithRealTimeProcessQuery(false))
+	localIP := net.IPv4(10, 0, 0, 5)
+	resolvers := []net.IP{net.IPv4(8, 8, 8, 8), net.IPv4(1, 1, 1, 1)}
+	for i, remoteIP := range resolvers {
+		e.updatePacketRecord(&packetInfo{localIP: localIP, localPort: 5353, remoteIP: remoteIP, remotePort: 53, protocol: "UDP", length: uint64(100 * (i + 1))})
+	}
+
+	stats := e.GetTrafficStats()
+	if len(stats) != 2 {
+		t.Fatalf("应按对端分为 2 条流量记录，实际 %d", len(stats))
+	}
+	for _, remoteIP := range resolvers {
+		v, ok := e.trafficMap.Load(flowKey("UDP", localIP, 5353, remoteIP, 53))
+		if !ok {
+			t.Fatalf("未找到对端 %s 的流量记录", remoteIP)
+		}
+		if tr := v.(*TrafficRecord); !tr.RemoteIP.Equal(remoteIP) {
+			t.Fatalf("RemoteIP 不匹配，期望 %s，实际 %s", remoteIP, tr.RemoteIP)
+		}
+	}
+
+	sockets := e.GetSocketStats()
+	if len(sockets) != 1 {
+		t.Fatalf("应汇总为 1 个套接字，实际 %d", len(sockets))
+	}
+	if sockets[0].FlowCount != 2 || sockets[0].BytesSent != 300 {
+		t.Fatalf("套接字汇总不匹配，期望 2 条连接 300 字节，实际 %d 条 %d 字节", sockets[0].FlowCount, sockets[0].BytesSent)
+	}
+}
+
+// 添加测试：按进程汇总流量，统计连接数、对端数，可执行文件路径和命令行取自最近一条连接
+func TestGetProcessStats(t *testing.T) {
+	e := NewEngine(WithRealTimeProcessQuery(false))
+	self := int32(os.Getpid())
+	add := func(pid int32, name, remote string, remotePort uint16, length uint64, inbound bool) {
+		e.updatePacketRecord(&packetInfo{
+			localIP:     net.IPv4(10, 0, 0, 5),
+			localPort:   uint16(40000 + pid%1000),
+			remoteIP:    net.ParseIP(remote),
+			remotePort:  remotePort,
+			protocol:    "TCP",
+			processName: name,
+			pid:         pid,
+			length:      length,
+			inbound:     inbound,
+		})
+	}
+	add(self, "self", "1.1.1.1", 443, 100, false)
+	add(self, "self", "1.1.1.1", 443, 300, true)
+	add(self, "self", "1.1.1.1", 80, 100, false)
+	add(self, "self", "8.8.8.8", 443, 100, false)
+	add(0, "", "9.9.9.9", 53, 50, false)
+	// 不再查询系统：进程退出或PID被复用后，仍使用连接记录中的进程信息
+	latest := time.Now().Add(time.Second)
+	e.trafficMap.Range(func(_, v any) bool {
+		tr := v.(*TrafficRecord)
+		if tr.ProcessPID == self {
+			tr.ProcessExe, tr.ProcessCmdline = "/old/self", "self"
+			if tr.RemoteIP.Equal(net.ParseIP("8.8.8.8")) {
+				tr.ProcessExe, tr.ProcessCmdline = "/usr/bin/self", "self -v"
+				tr.LastUpdate = latest
+			}
+		}
+		return true
+	})
+
+	stats := e.GetProcessStats()
+	if len(stats) != 2 {
+		t.Fatalf("应汇总为 2 个进程，实际 %d", len(stats))
+	}
+	p := stats[0]
+	if p.ProcessPID != self || p.BytesSent != 300 || p.BytesReceived != 300 || p.PacketsSent != 3 || p.PacketsReceived != 1 {
+		t.Fatalf("按流量排序的第一个进程统计不正确: %+v", p)
+	}
+	if p.FlowCount != 3 || p.RemoteHostCount != 2 {
+		t.Fatalf("连接数/对端数应为 3/2，实际 %d/%d", p.FlowCount, p.RemoteHostCount)
+	}
+	if p.Exe != "/usr/bin/self" || p.Cmdline != "self -v" {
+		t.Fatalf("可执行文件路径和命令行应取自最近一条连接，实际 %q %q", p.Exe, p.Cmdline)
+	}
+	if stats[1].ProcessPID != 0 || stats[1].Exe != "" {
+		t.Fatalf("未识别进程的流量应汇总为 PID 0: %+v", stats[1])
+	}
+}
+
+// 添加测试：按远程IP、国家和ASN汇总流量，按字节数排序并截取前N条
+func TestGeoAggregation(t *testing.T) {
+	geo := map[string]GeoIpInfo{
+		"1.1.1.1": {CountryCode: "AU", Country: "澳大利亚", ASN: 13335, ASOrg: "CLOUDFLARENET"},
+		"1.0.0.1": {CountryCode: "AU", Country: "澳大利亚", ASN: 13335, ASOrg: "CLOUDFLARENET"},
+		"8.8.8.8": {CountryCode: "US", Country: "美国", ASN: 15169, ASOrg: "GOOGLE"},
+	}
+	lookups := 0
+	e := NewEngine(WithRealTimeProcessQuery(false), WithGeoLookup(func(ip string) GeoIpInfo {
+		lookups++
+		return geo[ip]
+	}))
+	add := func(remote string, remotePort uint16, pid int32, length uint64) {
+		e.updatePacketRecord(&packetInfo{
+			localIP:    net.IPv4(10, 0, 0, 5),
+			localPort:  50000,
+			remoteIP:   net.ParseIP(remote),
+			remotePort: remotePort,
+			protocol:   "TCP",
+			pid:        pid,
+			length:     length,
+		})
+	}
+	add("1.1.1.1", 443, 100, 500)
+	add("1.1.1.1", 80, 101, 500)
+	add("1.0.0.1", 443, 100, 300)
+	add("8.8.8.8", 443, 100, 1200)
+	add("192.168.1.1", 53, 0, 10)
+
+	hosts := e.GetRemoteHostStats(2)
+	if len(hosts) != 2 || hosts[0].RemoteIP != "8.8.8.8" || hosts[1].RemoteIP != "1.1.1.1" {
+		t.Fatalf("按远程IP汇总的前2条不正确: %+v", hosts)
+	}
+	if hosts[1].FlowCount != 2 || hosts[1].ProcessCount != 2 || hosts[1].Geo.CountryCode != "AU" {
+		t.Fatalf("远程IP汇总的连接数/进程数/地理位置不正确: %+v", hosts[1])
+	}
+
+	countries := e.GetCountryStats(0)
+	if len(countries) != 3 || countries[0].Key != "AU" || countries[0].BytesSent != 1300 || countries[0].RemoteHostCount != 2 {
+		t.Fatalf("按国家汇总不正确: %+v", countries[0])
+	}
+	if countries[2].Key != "" || countries[2].BytesSent != 10 {
+		t.Fatalf("内网IP应汇总为空国家: %+v", countries[2])
+	}
+	asns := e.GetAsnStats(1)
+	if len(asns) != 1 || asns[0].Key != "AS13335" || asns[0].Name != "CLOUDFLARENET" || asns[0].FlowCount != 3 {
+		t.Fatalf("按ASN汇总不正确: %+v", asns)
+	}
+	// 内网IP不查询，每个公网IP只查询一次
+	if lookups != 3 {
+		t.Fatalf("地理位置应查询 3 次，实际 %d", lookups)
+	}
+
+	// 连接被清理后，缓存也随之删除
+	e.evictTrafficRecords(time.Now().Add(time.Hour), time.Minute)
+	if _, ok := e.geoCache.Load("8.8.8.8"); ok {
+		t.Fatal("连接清理后地理位置缓存应被删除")
+	}
+}
+
+// 添加测试：BPF过滤器的默认值，以及无效过滤器在打开网卡前返回错误
+func TestBPFFilter(t *testing.T) {
+	e := NewEngine()
+	if e.BPFFilter() != DefaultBPFFilter {
+		t.Fatalf("默认过滤器应为 %q，实际 %q", DefaultBPFFilter, e.BPFFilter())
+	}
+	e = NewEngine(WithBPFFilter("tcp port 443"))
+	if e.BPFFilter() != "tcp port 443" {
+		t.Fatalf("过滤器应为 tcp port 443，实际 %q", e.BPFFilter())
+	}
+	if err := ValidateBPFFilter(""); err != nil {
+		t.Fatalf("空过滤器表示使用默认值，不应报错: %v", err)
+	}
+
+	e.SetBPFFilter("tcp and and port")
+	err := e.RunContext(context.Background(), "netguard-test-nonexistent0")
+	if err == nil || !strings.Contains(err.Error(), "无效的BPF过滤器") {
+		t.Fatalf("无效的过滤器应返回错误，实际 %v", err)
+	}
+	if e.IsRunning() {
+		t.Fatal("过滤器无效时不应开始运行")
+	}
+}
+
+// 添加测试：统计协程判断地理位置数据库是否可用时，与设置数据库并发执行没有数据竞争
+func TestGeoipDbConcurrent(t *testing.T) {
+	geoipMutex.RLock()
+	file := geoipdbFile
+	geoipMutex.RUnlock()
+	t.Cleanup(func() {
+		geoipMutex.Lock()
+		geoipdbFile, geoipDb = file, nil
+		geoipMutex.Unlock()
+	})
+
+	missing := t.TempDir() + "/missing.mmdb"
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			geoipDbAvailable()
+		}()
+		go func() {
+			defer wg.Done()
+			SetGeoipDb(missing)
+		}()
+	}
+	wg.Wait()
+	if geoipDbAvailable() {
+		t.Fatal("数据库文件不存在时不应可用")
+	}
+}
+
+// 添加测试：后台启动失败时同步返回错误，且引擎不处于运行状态，可以再次启动
+func TestStartError(t *testing.T) {
+	e := NewEngine()
+	for i := 0; i < 2; i++ {
+		if err := e.Start(context.Background(), "netguard-test-nonexistent0"); err == nil {
+			t.Fatal("网卡不存在时应返回错误")
+		}
+		if e.IsRunning() {
+			t.Fatal("启动失败时不应处于运行状态")
+		}
+	}
+}
+
+// 添加测试：环回网卡监控开关的默认值和设置
+func TestLoopbackOption(t *testing.T) {
+	if NewEngine().Loopback() {
+		t.Fatal("默认不应监控环回网卡")
+	}
+	e := NewEngine(WithLoopback(true))
+	if !e.Loopback() {
+		t.Fatal("WithLoopback(true) 后应监控环回网卡")
+	}
+	e.SetLoopback(false)
+	if e.Loopback() {
+		t.Fatal("SetLoopback(false) 后不应监控环回网卡")
+	}
+}
+
+// 添加测试：抓包参数的默认值和自定义设置
+func TestCaptureOptions(t *testing.T) {
+	e := NewEngine()
+	if e.captureOpts.SnapLen != DEFAULT_SNAP_LEN || !e.captureOpts.Promiscuous || e.captureOpts.Timeout != 0 {
+		t.Fatalf("默认抓包参数应为 1600 字节、混杂模式、无限期等待，实际 %+v", e.captureOpts)
+	}
+	opts := DefaultCaptureOptions()
+	opts.Promiscuous = false
+	opts.BufferSize = 64 * 1024 * 1024
+	opts.ImmediateMode = true
+	e = NewEngine(WithCaptureOptions(opts))
+	if e.captureOpts != opts {
+		t.Fatalf("抓包参数应为 %+v，实际 %+v", opts, e.captureOpts)
+	}
+	e.SetCaptureOptions(CaptureOptions{SnapLen: 65535})
+	if e.captureOpts.SnapLen != 65535 || e.captureOpts.Promiscuous {
+		t.Fatalf("SetCaptureOptions 应整体替换抓包参数，实际 %+v", e.captureOpts)
+	}
+}
@@ -0,0 +1,194 @@
+//go:build linux
+// +build linux
+
+package netguard
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// sock_diag 相关常量，见 linux/sock_diag.h 和 linux/inet_diag.h
+const (
+	sockDiagByFamily    = 20 // SOCK_DIAG_BY_FAMILY
+	inetDiagReqV2Len    = 56 // sizeof(struct inet_diag_req_v2)
+	inetDiagMsgLen      = 72 // sizeof(struct inet_diag_msg)
+	inetDiagSockIDLen   = 48 // sizeof(struct inet_diag_sockid)
+	inetDiagAllStates   = 0xffffffff
+	inetDiagReqBytecode = 1 // INET_DIAG_REQ_BYTECODE，请求中附带的过滤程序
+	inetDiagBcSGE       = 2 // INET_DIAG_BC_S_GE，本地端口 >= 下一条指令的 no
+	inetDiagBcSLE       = 3 // INET_DIAG_BC_S_LE，本地端口 <= 下一条指令的 no
+	netlinkRecvBufSize  = 32 * 1024
+)
+
+// NetlinkResolver 通过 NETLINK_SOCK_DIAG 向内核查询套接字的 inode 和所属用户，
+// 再通过 /proc/<pid>/fd 找到打开该 inode 的进程。与 ss -p 的方式相同，内核只返回本地端口匹配的套接字，比解析 /proc/net 文本更快。
+type NetlinkResolver struct {
+	root  string
+	index *socketIndex
+}
+
+// NewNetlinkResolver 创建基于 NETLINK_SOCK_DIAG 的进程查找器。root 为 procfs 的挂载目录，为空时使用 /proc
+func NewNetlinkResolver(root string) *NetlinkResolver {
+	if root == "" {
+		root = "/proc"
+	}
+	return &NetlinkResolver{root: root, index: newSocketIndex(root)}
+}
+
+func (r *NetlinkResolver) procfsRoot() string {
+	return r.root
+}
+
+// Lookup 查找拥有本地套接字的进程
+func (r *NetlinkResolver) Lookup(protocol string, localIP net.IP, localPort uint16) (ProcessInfo, error) {
+	var proto uint8
+	switch protocol {
+	case "TCP":
+		proto = syscall.IPPROTO_TCP
+	case "UDP":
+		proto = syscall.IPPROTO_UDP
+	default:
+		return ProcessInfo{}, fmt.Errorf("不支持的协议: %s", protocol)
+	}
+	// IPv4 套接字也可能由监听在 :: 上的IPv6套接字接受
+	families := []uint8{syscall.AF_INET6}
+	if localIP.To4() != nil {
+		families = []uint8{syscall.AF_INET, syscall.AF_INET6}
+	}
+	var wildcard *procNetEntry
+	for _, family := range families {
+		entries, err := sockDiagDump(family, proto, localPort)
+		if err != nil {
+			return ProcessInfo{}, err
+		}
+		for i := range entries {
+			entry := &entries[i]
+			if entry.localPort != localPort || entry.inode == 0 {
+				continue
+			}
+			if entry.localIP.Equal(localIP) {
+				return r.index.lookup(entry.inode, entry.uid)
+			}
+			if entry.localIP.IsUnspecified() && wildcard == nil {
+				wildcard = entry
+			}
+		}
+	}
+	if wildcard != nil {
+		return r.index.lookup(wildcard.inode, wildcard.uid)
+	}
+	return ProcessInfo{}, ErrProcessNotFound
+}
+
+// sockDiagDump 获取内核中指定地址族、协议和本地端口的套接字。端口由内核过滤，只返回匹配的套接字
+func sockDiagDump(family, proto uint8, port uint16) ([]procNetEntry, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, syscall.NETLINK_INET_DIAG)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	defer syscall.Close(fd)
+
+	req := newSockDiagRequest(family, proto, port)
+	if err = syscall.Sendto(fd, req, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return nil, os.NewSyscallError("sendto", err)
+	}
+
+	var entries []procNetEntry
+	buf := make([]byte, netlinkRecvBufSize)
+	for {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return nil, os.NewSyscallError("recvfrom", err)
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return nil, err
+		}
+		for _, msg := range msgs {
+			switch msg.Header.Type {
+			case syscall.NLMSG_DONE:
+				return entries, nil
+			case syscall.NLMSG_ERROR:
+				if len(msg.Data) >= 4 {
+					if errno := int32(binary.NativeEndian.Uint32(msg.Data)); errno != 0 {
+						return nil, os.NewSyscallError("sock_diag", syscall.Errno(-errno))
+					}
+				}
+				return entries, nil
+			case sockDiagByFamily:
+				if entry, ok := parseInetDiagMsg(msg.Data); ok {
+					entries = append(entries, entry)
+				}
+			}
+		}
+	}
+}
+
+// newSockDiagRequest 构造 nlmsghdr + inet_diag_req_v2 + 过滤程序的请求，获取本地端口为 port 的所有状态的套接字。
+// inet_diag_sockid 中的端口只用于精确查找单个连接，需要完整的四元组，因此改用过滤程序按本地端口过滤
+func newSockDiagRequest(family, proto uint8, port uint16) []byte {
+	bc := portFilterBytecode(port)
+	b := make([]byte, syscall.NLMSG_HDRLEN+inetDiagReqV2Len+syscall.SizeofRtAttr+len(bc))
+	binary.NativeEndian.PutUint32(b[0:4], uint32(len(b)))
+	binary.NativeEndian.PutUint16(b[4:6], sockDiagByFamily)
+	binary.NativeEndian.PutUint16(b[6:8], syscall.NLM_F_REQUEST|syscall.NLM_F_DUMP)
+	binary.NativeEndian.PutUint32(b[8:12], 1)
+	req := b[syscall.NLMSG_HDRLEN:]
+	req[0] = family
+	req[1] = proto
+	binary.NativeEndian.PutUint32(req[4:8], inetDiagAllStates)
+	// 其余的 inet_diag_sockid 全部为0
+	attr := req[inetDiagReqV2Len:]
+	binary.NativeEndian.PutUint16(attr[0:2], uint16(syscall.SizeofRtAttr+len(bc)))
+	binary.NativeEndian.PutUint16(attr[2:4], inetDiagReqBytecode)
+	copy(attr[syscall.SizeofRtAttr:], bc)
+	return b
+}
+
+// portFilterBytecode 构造只接受本地端口为 port 的过滤程序，由两条 inet_diag_bc_op{code, yes, no} 比较指令组成，
+// 每条比较指令后跟一条只在 no 中存放端口的指令。条件成立时跳过 yes 字节，否则跳过 no 字节；
+// 恰好执行到程序末尾表示接受，跳出末尾表示拒绝
+func portFilterBytecode(port uint16) []byte {
+	const opLen = 8 // 比较指令 + 端口
+	bc := make([]byte, 2*opLen)
+	for i, code := range []uint8{inetDiagBcSGE, inetDiagBcSLE} {
+		op := bc[i*opLen:]
+		op[0] = code
+		op[1] = opLen
+		binary.NativeEndian.PutUint16(op[2:4], uint16(len(bc)-i*opLen+4))
+		binary.NativeEndian.PutUint16(op[6:8], port)
+	}
+	return bc
+}
+
+// parseInetDiagMsg 解析 inet_diag_msg：
+//
+//	family(1) state(1) timer(1) retrans(1) id(48) expires(4) rqueue(4) wqueue(4) uid(4) inode(4)
+//
+// id 中的端口和地址为网络字节序
+func parseInetDiagMsg(b []byte) (procNetEntry, bool) {
+	if len(b) < inetDiagMsgLen {
+		return procNetEntry{}, false
+	}
+	id := b[4 : 4+inetDiagSockIDLen]
+	var ip net.IP
+	switch b[0] {
+	case syscall.AF_INET:
+		ip = net.IP(append([]byte(nil), id[4:8]...))
+	case syscall.AF_INET6:
+		ip = net.IP(append([]byte(nil), id[4:20]...))
+	default:
+		return procNetEntry{}, false
+	}
+	tail := b[4+inetDiagSockIDLen:]
+	return procNetEntry{
+		localIP:   ip,
+		localPort: binary.BigEndian.Uint16(id[0:2]),
+		uid:       binary.NativeEndian.Uint32(tail[12:16]),
+		inode:     uint64(binary.NativeEndian.Uint32(tail[16:20])),
+	}, true
+}
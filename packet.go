@@ -3,12 +3,12 @@ package netguard
 import (
 	"fmt"
 	"net"
+	"strconv"
 	"time"
 
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 	"github.com/iotames/netguard/log"
-	"github.com/shirou/gopsutil/v3/process"
 )
 
 func getPacketNetworkInfo(packet gopacket.Packet) (srcIP, dstIP net.IP, protocol layers.IPProtocol, ok bool) {
@@ -35,8 +35,20 @@ func getPacketNetworkInfo(packet gopacket.Packet) (srcIP, dstIP net.IP, protocol
 	return
 }
 
-// processCapturedPacket 处理捕获到的数据包
-func processCapturedPacket(packet gopacket.Packet) {
+// flowHash 计算数据包所属连接的哈希值。同一连接两个方向的数据包哈希值相同
+func flowHash(packet gopacket.Packet) uint64 {
+	var h uint64
+	if nl := packet.NetworkLayer(); nl != nil {
+		h = nl.NetworkFlow().FastHash()
+	}
+	if tl := packet.TransportLayer(); tl != nil {
+		h = h*31 + tl.TransportFlow().FastHash()
+	}
+	return h
+}
+
+// processCapturedPacket 处理捕获到的数据包。iface 为抓到该数据包的网卡名
+func (e *Engine) processCapturedPacket(packet gopacket.Packet, iface string) {
 	// 添加recover防止单个包处理失败影响整个程序
 	defer func() {
 		if r := recover(); r != nil {
@@ -50,6 +62,7 @@ func processCapturedPacket(packet gopacket.Packet) {
 
 	// 获取传输层（TCP/UDP）信息
 	var srcPort, dstPort uint16
+	var tcpLayer *layers.TCP
 	// 先检查 TransportLayer 是否为 nil，避免直接类型断言为 nil 导致不可预期行为
 	transportLayer := packet.TransportLayer()
 	if transportLayer == nil {
@@ -59,6 +72,7 @@ func processCapturedPacket(packet gopacket.Packet) {
 	case *layers.TCP:
 		srcPort = uint16(tl.SrcPort)
 		dstPort = uint16(tl.DstPort)
+		tcpLayer = tl
 	case *layers.UDP:
 		srcPort = uint16(tl.SrcPort)
 		dstPort = uint16(tl.DstPort)
@@ -66,75 +80,135 @@ func processCapturedPacket(packet gopacket.Packet) {
 		return
 	}
 
-	packetLength := uint64(len(packet.Data()))
+	// 记录DNS应答中的域名，用于显示远程IP对应的域名
+	e.handleDNS(packet, packet.Metadata().Timestamp)
 
-	// 判断流量方向（简化逻辑：假设目的IP是本机则为入流量）
-	isInbound := isLocalIP(dstIP) // 需要实现 isLocalIP 函数检查 dstIP 是否为本地IP
+	dir, hostLocal := e.classifyPacket(srcIP, srcPort, dstIP, dstPort)
+	pinfo := &packetInfo{
+		protocol:  protocol.String(),
+		length:    uint64(len(packet.Data())),
+		inbound:   dir == DirectionInbound,
+		transit:   dir == DirectionTransit,
+		noProcess: !hostLocal,
+		timestamp: packet.Metadata().Timestamp,
+		tcp:       tcpLayer,
+		iface:     iface,
+	}
 
-	// 确定本地和远程地址
-	var localIP, remoteIP net.IP
-	var localPort, remotePort uint16
+	if dir == DirectionInbound && e.isLocalIP(srcIP) {
+		// 本机进程之间的通信（如访问 127.0.0.1）：发送方和接收方是两个套接字，各记录一条连接，分别归属各自的进程
+		pinfo.localPeer = true
+		out := *pinfo
+		out.inbound = false
+		out.localIP, out.localPort, out.remoteIP, out.remotePort = srcIP, srcPort, dstIP, dstPort
+		e.updatePacketRecord(&out)
+	}
 
-	if isInbound {
+	// 确定本地和远程地址
+	switch {
+	case pinfo.transit:
+		// 转发流量两端都不是本机，地址较小的一端作为本地一端，两个方向的数据包合并为一条记录
+		if transitEndpoints(srcIP, srcPort, dstIP, dstPort) {
+			pinfo.localIP, pinfo.localPort, pinfo.remoteIP, pinfo.remotePort = srcIP, srcPort, dstIP, dstPort
+		} else {
+			pinfo.inbound = true
+			pinfo.localIP, pinfo.localPort, pinfo.remoteIP, pinfo.remotePort = dstIP, dstPort, srcIP, srcPort
+		}
+	case pinfo.inbound:
 		// 入流量，通过目的IP和端口查找进程
-		localIP, localPort, remoteIP, remotePort = dstIP, dstPort, srcIP, srcPort
-	} else {
+		pinfo.localIP, pinfo.localPort, pinfo.remoteIP, pinfo.remotePort = dstIP, dstPort, srcIP, srcPort
+	default:
 		// 出流量，通过源IP和端口查找进程
-		localIP, localPort, remoteIP, remotePort = srcIP, srcPort, dstIP, dstPort
-	}
-	// 关键：通过连接映射表查找进程信息
-	var pid int32
-	var processName string
-	pid = findPidByConnection(localIP, localPort)
-	if pid > 0 {
-		proc, err := process.NewProcess(pid)
-		if err == nil {
-			processName, _ = proc.Name()
-		}
+		pinfo.localIP, pinfo.localPort, pinfo.remoteIP, pinfo.remotePort = srcIP, srcPort, dstIP, dstPort
 	}
 
 	// 更新流量统计
-	updatePacketRecord(localIP, localPort, remoteIP, remotePort, protocol.String(), processName, pid, packetLength, isInbound)
+	e.updatePacketRecord(pinfo)
+}
+
+// flowKey 生成连接的五元组键，如 "UDP 10.0.0.5:54321->8.8.8.8:53"
+func flowKey(protocol string, localIP net.IP, localPort uint16, remoteIP net.IP, remotePort uint16) string {
+	local := net.JoinHostPort(localIP.String(), strconv.Itoa(int(localPort)))
+	remote := net.JoinHostPort(remoteIP.String(), strconv.Itoa(int(remotePort)))
+	return protocol + " " + local + "->" + remote
+}
+
+// packetInfo 从单个数据包中解析出的流量信息
+type packetInfo struct {
+	localIP     net.IP
+	localPort   uint16
+	remoteIP    net.IP
+	remotePort  uint16
+	protocol    string
+	processName string
+	pid         int32
+	length      uint64      // 数据包大小（字节数）
+	inbound     bool        // 是否为入站流量
+	timestamp   time.Time   // 抓包时间。离线回放时为文件中记录的时间
+	tcp         *layers.TCP // TCP层，用于跟踪连接状态。UDP数据包为 nil
+	iface       string      // 抓到该数据包的网卡名。离线回放时为空
+	localPeer   bool        // 对端也是本机
+	transit     bool        // 转发流量，两端都不是本机或本地网络
+	noProcess   bool        // 本地一端不是本机IP，不查找进程
 }
 
 // updatePacketRecord 更新流量统计信息
-func updatePacketRecord(localIP net.IP, localPort uint16, remoteIP net.IP, remotePort uint16, protocol, processName string, pid int32, packetLength uint64, isInbound bool) {
-	var direction, arrow string
-	if isInbound {
-		direction = "入站"
+func (e *Engine) updatePacketRecord(p *packetInfo) {
+	var arrow string
+	dir := DirectionOutbound
+	if p.inbound {
+		dir = DirectionInbound
 		arrow = "<-"
 	} else {
-		direction = "出站"
 		arrow = "->"
 	}
-	// 使用本地IP和端口作为键，方便匹配本地进程
-	key := fmt.Sprintf("%s:%d", localIP.String(), localPort)
+	// 转发流量单独统计，不与本机的连接混在一起
+	flows := &e.trafficMap
+	if p.transit {
+		dir = DirectionTransit
+		flows = &e.transitMap
+	}
+	direction := dir.String()
+	now := p.timestamp
+	if now.IsZero() {
+		now = time.Now()
+	}
+	localIP, localPort, remoteIP, remotePort := p.localIP, p.localPort, p.remoteIP, p.remotePort
+	protocol, processName, pid, packetLength := p.protocol, p.processName, p.pid, p.length
+	// 使用协议+本地地址+远程地址的五元组作为键，同一个本地端口与不同对端的通信分开统计
+	key := flowKey(protocol, localIP, localPort, remoteIP, remotePort)
+	if p.iface != "" {
+		// 同时监控多个网卡时，同一连接可能经过多个网卡（如网关转发），按网卡分别统计
+		key = p.iface + " " + key
+	}
 
-	record, exists := trafficMap.Load(key)
+	record, exists := flows.Load(key)
 	if !exists {
-		// 新建连接
-		if realTimeProcessQuery && pid == 0 {
-			// 强制查询进程信息
-			pid = queryProcessRealTime(localIP, localPort)
-			if pid > 0 {
-				proc, err := process.NewProcess(pid)
-				if err == nil {
-					processName, _ = proc.Name()
-				}
-			}
+		// 新建连接。关键：查找连接所属的进程。
+		// 离线回放的数据包来自其他主机，转发流量和本地网络中其他主机的连接不属于本机进程，都不查询
+		proc := resolvedProcess{ProcessMeta: ProcessMeta{PID: pid, Name: processName}}
+		if !e.offline && !p.noProcess && pid == 0 {
+			proc = e.resolveProcess(protocol, localIP, localPort)
+			pid, processName = proc.PID, proc.Name
 		}
-		record = &TrafficRecord{
+		newRecord := &TrafficRecord{
 			LocalIP:     localIP,
 			LocalPort:   localPort,
 			RemoteIP:    remoteIP,
 			RemotePort:  remotePort,
 			Protocol:    protocol,
-			ProcessName: processName,
-			ProcessPID:  pid,
-			LastUpdate:  time.Now(),
-			LastLogTime: time.Now(), // 新增：初始化 LastLogTime，避免新建就触发周期日志
+			Interface:   p.iface,
+			LocalPeer:   p.localPeer,
+			RemoteClass: e.ClassifyAddress(remoteIP),
+			RemoteHost:  e.dnsCache.lookup(remoteIP, now),
+			Direction:   dir,
+			FirstSeen:   now,
+			LastUpdate:  now,
+			LastLogTime: now, // 新增：初始化 LastLogTime，避免新建就触发周期日志
+			lastResolve: now,
 		}
-		trafficMap.Store(key, record)
+		newRecord.setProcess(proc)
+		record, _ = flows.LoadOrStore(key, newRecord)
 		msg := fmt.Sprintf("新建连接%s：", arrow)
 		log.Debug(msg, "方向", direction, "本地IP", localIP, "本地端口", localPort, "远程IP", remoteIP, "远程端口", remotePort, "进程", processName, "PID", pid, "字节大小", packetLength)
 	}
@@ -146,24 +220,39 @@ func updatePacketRecord(localIP net.IP, localPort uint16, remoteIP net.IP, remot
 		// 当前数据包大小（字节数）
 		tr.BytesCurrentLen = packetLength
 		// 是否为入站流量
-		tr.Inbound = isInbound
+		tr.Inbound = p.inbound
+		tr.Direction = dir
 
-		if isInbound {
+		if p.inbound {
 			tr.BytesReceived += packetLength
+			tr.PacketsReceived++
 		} else {
 			tr.BytesSent += packetLength
+			tr.PacketsSent++
 		}
+		tr.rates.add(now, packetLength)
+		tr.Rate = tr.rates.at(now)
 
 		// 基于时间间隔的日志：每10秒记录一次该连接的流量统计
-		if time.Since(tr.LastLogTime) > 10*time.Second {
+		if now.Sub(tr.LastLogTime) > 10*time.Second {
 			// 记录流量统计而非单个包
 			msg := fmt.Sprintf("流量统计%s：", arrow)
 			log.Debug(msg, "方向", direction, "本地端口", localPort, "远程IP", remoteIP, "远程端口", remotePort, "协议", protocol,
 				"进程", processName, "PID", pid, "累计发送字节", tr.BytesSent, "累计接收字节", tr.BytesReceived, "当前包字节", packetLength)
-			tr.LastLogTime = time.Now()
+			tr.LastLogTime = now
 		}
 
-		tr.LastUpdate = time.Now()
+		// 只让时间向前推进
+		if now.After(tr.LastUpdate) {
+			tr.LastUpdate = now
+		}
+		var retransmitted bool
+		if p.tcp != nil {
+			retrans := tr.Retransmissions
+			tr.updateTCP(p.tcp, p.inbound, now)
+			retransmitted = tr.Retransmissions > retrans
+			tr.updateTLS(p.tcp)
+		}
 		// 更新其他可能变化的信息
 		if processName != "" {
 			tr.ProcessName = processName
@@ -171,17 +260,52 @@ func updatePacketRecord(localIP net.IP, localPort uint16, remoteIP net.IP, remot
 		if pid > 0 {
 			tr.ProcessPID = pid
 		}
+		// 新建时未识别到进程（如套接字尚未出现在系统连接表中），每隔一段时间重新查找
+		if tr.ProcessPID == 0 && !e.offline && !p.noProcess && now.Sub(tr.lastResolve) >= processRetryInterval {
+			tr.lastResolve = now
+			// 仍未找到进程时只补充套接字所属的用户，不覆盖之前查到的用户
+			proc := e.resolveProcess(protocol, localIP, localPort)
+			if proc.User == "" {
+				proc.User = tr.ProcessUser
+			}
+			if proc.PID > 0 {
+				tr.setProcess(proc)
+			} else {
+				tr.ProcessUser = proc.User
+			}
+		}
+
+		// 明文HTTP请求。在更新进程信息之后解析，请求中记录连接所属的进程；重传的数据包不重复记录
+		var httpReq *HTTPRequest
+		if p.tcp != nil && !retransmitted {
+			httpReq = tr.updateHTTP(p, now)
+		}
 
-		tr.Msg = fmt.Sprintf("%s-%s, Remote(%s:%d), Process(%d-%s), Length(%d/%d)", tr.Protocol, direction, tr.RemoteIP.String(), remotePort, tr.ProcessPID, tr.ProcessName, tr.BytesCurrentLen, tr.BytesReceived+tr.BytesSent)
+		// 连接建立时可能还没有抓到DNS应答，如DNS查询早于抓包开始
+		if tr.RemoteHost == "" {
+			tr.RemoteHost = e.dnsCache.lookup(remoteIP, now)
+		}
+		remote := net.JoinHostPort(tr.RemoteIP.String(), strconv.Itoa(int(remotePort)))
+		host := tr.RemoteHost
+		if host == "" {
+			// 没有抓到DNS应答时，使用TLS握手中的域名
+			host = tr.TLS.ServerName
+		}
+		if host != "" {
+			remote = host + " " + remote
+		}
+		tr.Msg = fmt.Sprintf("%s-%s, Remote(%s), Process(%d-%s), Length(%d/%d)", tr.Protocol, direction, remote, tr.ProcessPID, tr.ProcessName, tr.BytesCurrentLen, tr.BytesReceived+tr.BytesSent)
 
-		if hookPacket != nil {
-			hookPacket(tr)
+		if e.hookPacket != nil {
+			e.hookPacket(tr)
+		}
+		if httpReq != nil && e.hookHTTPRequest != nil {
+			e.hookHTTPRequest(httpReq)
 		}
 	}
 }
 
+// SetPacketHook 设置默认引擎的数据包钩子函数
 func SetPacketHook(packetHook func(info *TrafficRecord)) {
-	hookPacket = packetHook
+	DefaultEngine().SetPacketHook(packetHook)
 }
-
-var hookPacket func(info *TrafficRecord)
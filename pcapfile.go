@@ -0,0 +1,151 @@
+package netguard
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/iotames/netguard/log"
+)
+
+// pcapng 文件以 Section Header Block 开头，块类型固定为 0x0A0D0D0A
+const pcapngBlockTypeSHB = 0x0A0D0D0A
+
+// RunWithFile 使用默认引擎回放离线抓包文件（.pcap 或 .pcapng）
+func RunWithFile(filename string) error {
+	return DefaultEngine().RunWithFile(context.Background(), filename)
+}
+
+// RunWithFile 回放离线抓包文件（.pcap 或 .pcapng），走与网卡抓包相同的处理流程。
+// 文件读完或 ctx 被取消后返回。流量记录的时间使用文件中记录的抓包时间。
+// 回放的数据包来自其他主机，不会查询本机进程。可使用 WithLocalIPs 指定抓包主机的IP以判断流量方向。
+func (e *Engine) RunWithFile(ctx context.Context, filename string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done, err := e.beginRun(cancel, true)
+	if err != nil {
+		return err
+	}
+	defer e.endRun(done)
+
+	reader, err := openPcapFile(filename)
+	if err != nil {
+		log.Error("打开抓包文件失败:", "错误", err, "文件", filename)
+		return err
+	}
+	defer reader.Close()
+	log.Info("开始回放抓包文件", "文件", filename, "链路类型", reader.LinkType())
+	e.capture(ctx, captureSource{reader: reader})
+	return nil
+}
+
+// pcapDataReader pcapgo.Reader 和 pcapgo.NgReader 的共同方法
+type pcapDataReader interface {
+	gopacket.PacketDataSource
+	LinkType() layers.LinkType
+}
+
+// pcapFileReader 离线抓包文件的数据包来源
+type pcapFileReader struct {
+	pcapDataReader
+	f      *os.File
+	closed atomic.Bool
+	ng     *pcapgo.NgReader // pcapng 文件的读取器，pcap 文件为 nil
+	// pcapng 文件打开时预读的第一个数据包，用于得到文件的链路类型
+	first    *filePacket
+	linkType layers.LinkType
+	// 最近读取的数据包的链路类型。pcapng 文件中每个网卡的链路类型可能不同，见 Decode
+	current layers.LinkType
+}
+
+// filePacket 从文件中读取的一个数据包
+type filePacket struct {
+	data []byte
+	ci   gopacket.CaptureInfo
+	err  error
+}
+
+// openPcapFile 打开离线抓包文件。根据文件头自动识别 pcap 和 pcapng 格式。
+func openPcapFile(filename string) (*pcapFileReader, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(f)
+	magic, err := br.Peek(4)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("读取抓包文件(%s)头失败: %w", filename, err)
+	}
+	var r pcapDataReader
+	var ng *pcapgo.NgReader
+	// Section Header Block 的块类型是回文字节序列，大小端读取结果相同
+	if binary.LittleEndian.Uint32(magic) == pcapngBlockTypeSHB {
+		// 同时录制多个网卡的文件中，各网卡的链路类型可能不同。默认选项会跳过与第一个网卡链路类型不同的数据包
+		ng, err = pcapgo.NewNgReader(br, pcapgo.NgReaderOptions{WantMixedLinkType: true})
+		r = ng
+	} else {
+		r, err = pcapgo.NewReader(br)
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("解析抓包文件(%s)失败: %w", filename, err)
+	}
+	reader := &pcapFileReader{pcapDataReader: r, f: f, ng: ng, linkType: r.LinkType()}
+	if ng != nil {
+		// WantMixedLinkType 时 NgReader.LinkType 无效，以第一个数据包所属网卡的链路类型作为文件的链路类型
+		data, ci, err := reader.readPacket()
+		reader.first = &filePacket{data: data, ci: ci, err: err}
+		reader.linkType = reader.current
+	}
+	reader.current = reader.linkType
+	return reader, nil
+}
+
+// LinkType 文件的链路类型。pcapng 文件为第一个数据包所属网卡的链路类型
+func (r *pcapFileReader) LinkType() layers.LinkType {
+	return r.linkType
+}
+
+func (r *pcapFileReader) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	if r.closed.Load() {
+		return nil, gopacket.CaptureInfo{}, io.EOF
+	}
+	if first := r.first; first != nil {
+		r.first = nil
+		r.current = r.linkType
+		return first.data, first.ci, first.err
+	}
+	return r.readPacket()
+}
+
+// readPacket 读取下一个数据包，并记录其所属网卡的链路类型
+func (r *pcapFileReader) readPacket() ([]byte, gopacket.CaptureInfo, error) {
+	data, ci, err := r.pcapDataReader.ReadPacketData()
+	if err == nil && r.ng != nil && len(ci.AncillaryData) > 0 {
+		if linkType, ok := ci.AncillaryData[0].(layers.LinkType); ok {
+			r.current = linkType
+		}
+	}
+	return data, ci, err
+}
+
+// Decode 按最近读取的数据包所属网卡的链路类型解码。
+// gopacket.PacketSource 在同一个协程中依次读取和解码每个数据包，因此解码的总是刚读取的数据包
+func (r *pcapFileReader) Decode(data []byte, p gopacket.PacketBuilder) error {
+	return r.current.Decode(data, p)
+}
+
+// Close 关闭文件。之后的读取都返回 io.EOF，使 packetSource 结束
+func (r *pcapFileReader) Close() {
+	if r.closed.CompareAndSwap(false, true) {
+		r.f.Close()
+	}
+}
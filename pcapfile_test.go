@@ -0,0 +1,325 @@
+package netguard
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+	"github.com/google/gopacket/pcapgo"
+)
+
+// testPacket 写入测试抓包文件的数据包
+type testPacket struct {
+	ts   time.Time
+	data []byte
+}
+
+// serializeEthernet 在给定的网络层和传输层外包装以太网帧头并序列化
+func serializeEthernet(t *testing.T, ipLayer gopacket.NetworkLayer, ls ...gopacket.SerializableLayer) []byte {
+	t.Helper()
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55},
+		DstMAC:       net.HardwareAddr{0x66, 0x77, 0x88, 0x99, 0xaa, 0xbb},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	if _, ok := ipLayer.(*layers.IPv6); ok {
+		eth.EthernetType = layers.EthernetTypeIPv6
+	}
+	all := append([]gopacket.SerializableLayer{eth, ipLayer.(gopacket.SerializableLayer)}, ls...)
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, all...); err != nil {
+		t.Fatalf("序列化数据包失败: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// newTestIPLayer 根据IP版本创建网络层
+func newTestIPLayer(src, dst string, proto layers.IPProtocol) gopacket.NetworkLayer {
+	srcIP, dstIP := net.ParseIP(src), net.ParseIP(dst)
+	if srcIP.To4() == nil {
+		return &layers.IPv6{Version: 6, SrcIP: srcIP, DstIP: dstIP, NextHeader: proto, HopLimit: 64}
+	}
+	return &layers.IPv4{Version: 4, TTL: 64, SrcIP: srcIP.To4(), DstIP: dstIP.To4(), Protocol: proto}
+}
+
+// newTCPPacket 构造以太网+IP+TCP数据包。tcp 为 nil 时默认只设置 ACK 标志
+func newTCPPacket(t *testing.T, src, dst string, sport, dport uint16, tcp *layers.TCP, payload []byte) []byte {
+	t.Helper()
+	if tcp == nil {
+		tcp = &layers.TCP{ACK: true}
+	}
+	tcp.SrcPort, tcp.DstPort = layers.TCPPort(sport), layers.TCPPort(dport)
+	if tcp.Window == 0 {
+		tcp.Window = 65535
+	}
+	ipLayer := newTestIPLayer(src, dst, layers.IPProtocolTCP)
+	tcp.SetNetworkLayerForChecksum(ipLayer)
+	return serializeEthernet(t, ipLayer, tcp, gopacket.Payload(payload))
+}
+
+// newUDPPacket 构造以太网+IP+UDP数据包
+func newUDPPacket(t *testing.T, src, dst string, sport, dport uint16, payload []byte) []byte {
+	t.Helper()
+	udp := &layers.UDP{SrcPort: layers.UDPPort(sport), DstPort: layers.UDPPort(dport)}
+	ipLayer := newTestIPLayer(src, dst, layers.IPProtocolUDP)
+	udp.SetNetworkLayerForChecksum(ipLayer)
+	return serializeEthernet(t, ipLayer, udp, gopacket.Payload(payload))
+}
+
+// writeTestPcap 把数据包写入临时目录的抓包文件，ng 为 true 时写入 pcapng 格式
+func writeTestPcap(t *testing.T, packets []testPacket, ng bool) string {
+	t.Helper()
+	fpath := filepath.Join(t.TempDir(), "test.pcap")
+	if ng {
+		fpath += "ng"
+	}
+	f, err := os.Create(fpath)
+	if err != nil {
+		t.Fatalf("创建抓包文件失败: %v", err)
+	}
+	defer f.Close()
+
+	var write func(ci gopacket.CaptureInfo, data []byte) error
+	var ngw *pcapgo.NgWriter
+	if ng {
+		ngw, err = pcapgo.NewNgWriter(f, layers.LinkTypeEthernet)
+		if err != nil {
+			t.Fatalf("创建 pcapng writer 失败: %v", err)
+		}
+		write = ngw.WritePacket
+	} else {
+		w := pcapgo.NewWriter(f)
+		if err = w.WriteFileHeader(65535, layers.LinkTypeEthernet); err != nil {
+			t.Fatalf("写入 pcap 文件头失败: %v", err)
+		}
+		write = w.WritePacket
+	}
+	for _, p := range packets {
+		ci := gopacket.CaptureInfo{Timestamp: p.ts, CaptureLength: len(p.data), Length: len(p.data)}
+		if err = write(ci, p.data); err != nil {
+			t.Fatalf("写入数据包失败: %v", err)
+		}
+	}
+	if ngw != nil {
+		if err = ngw.Flush(); err != nil {
+			t.Fatalf("写入 pcapng 文件失败: %v", err)
+		}
+	}
+	return fpath
+}
+
+// replayTestPcap 使用指定本机IP的新引擎回放数据包，返回引擎以便检查统计结果
+func replayTestPcap(t *testing.T, packets []testPacket, ng bool, opts ...Option) *Engine {
+	t.Helper()
+	fpath := writeTestPcap(t, packets, ng)
+	opts = append([]Option{WithLocalIPs(net.ParseIP("192.168.1.10"), net.ParseIP("fd00::10"))}, opts...)
+	e := NewEngine(opts...)
+	if err := e.RunWithFile(context.Background(), fpath); err != nil {
+		t.Fatalf("回放抓包文件失败: %v", err)
+	}
+	return e
+}
+
+func TestRunWithFile(t *testing.T) {
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	var packets []testPacket
+	var sent, received uint64
+	for i := 0; i < 3; i++ {
+		data := newTCPPacket(t, "192.168.1.10", "93.184.216.34", 50000, 443, nil, []byte("request"))
+		sent += uint64(len(data))
+		packets = append(packets, testPacket{ts: base.Add(time.Duration(i) * time.Second), data: data})
+	}
+	for i := 0; i < 2; i++ {
+		data := newTCPPacket(t, "93.184.216.34", "192.168.1.10", 443, 50000, nil, []byte("response body"))
+		received += uint64(len(data))
+		packets = append(packets, testPacket{ts: base.Add(time.Duration(3+i) * time.Second), data: data})
+	}
+
+	for _, ng := range []bool{false, true} {
+		t.Run(fmt.Sprintf("pcapng=%v", ng), func(t *testing.T) {
+			e := replayTestPcap(t, packets, ng)
+			if e.IsRunning() {
+				t.Fatal("回放结束后引擎不应处于运行状态")
+			}
+			stats := e.GetTrafficStats()
+			if len(stats) != 1 {
+				t.Fatalf("应有 1 条流量记录，实际 %d", len(stats))
+			}
+			tr := stats[0]
+			if !tr.LocalIP.Equal(net.ParseIP("192.168.1.10")) || tr.LocalPort != 50000 {
+				t.Fatalf("本地地址不匹配，实际 %s:%d", tr.LocalIP, tr.LocalPort)
+			}
+			if tr.BytesSent != sent || tr.BytesReceived != received {
+				t.Fatalf("字节数不匹配，期望 %d/%d，实际 %d/%d", sent, received, tr.BytesSent, tr.BytesReceived)
+			}
+			if tr.ProcessPID != 0 {
+				t.Fatalf("离线回放不应查询本机进程，实际 PID %d", tr.ProcessPID)
+			}
+			if want := base.Add(4 * time.Second); !tr.LastUpdate.Equal(want) {
+				t.Fatalf("LastUpdate 应使用抓包时间 %v，实际 %v", want, tr.LastUpdate)
+			}
+		})
+	}
+}
+
+func TestOpenPcapFileInvalid(t *testing.T) {
+	fpath := filepath.Join(t.TempDir(), "bad.pcap")
+	if err := os.WriteFile(fpath, []byte("not a pcap file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	e := NewEngine()
+	if err := e.RunWithFile(context.Background(), fpath); err == nil {
+		t.Fatal("无效的抓包文件应返回错误")
+	}
+	if e.IsRunning() {
+		t.Fatal("启动失败后引擎不应处于运行状态")
+	}
+}
+
+// 添加测试：多个数据包来源同时抓包，流量记录带上网卡名，同一连接经过两个网卡时分别统计
+func TestCaptureMultipleSources(t *testing.T) {
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	eth0 := []testPacket{
+		{ts: base, data: newTCPPacket(t, "192.168.1.10", "93.184.216.34", 50000, 443, nil, []byte("request"))},
+		{ts: base.Add(time.Second), data: newUDPPacket(t, "192.168.1.10", "8.8.8.8", 5353, 53, []byte("query"))},
+	}
+	eth1 := []testPacket{
+		{ts: base, data: newTCPPacket(t, "192.168.1.10", "93.184.216.34", 50000, 443, nil, []byte("request"))},
+	}
+	e := NewEngine(WithLocalIPs(net.ParseIP("192.168.1.10")))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done, err := e.beginRun(cancel, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sources []captureSource
+	for _, src := range []struct {
+		iface   string
+		packets []testPacket
+	}{{"eth0", eth0}, {"eth1", eth1}} {
+		reader, err := openPcapFile(writeTestPcap(t, src.packets, false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sources = append(sources, captureSource{iface: src.iface, reader: reader})
+	}
+	e.capture(ctx, sources...)
+	e.endRun(done)
+
+	if stats := e.GetTrafficStats(); len(stats) != 3 {
+		t.Fatalf("两个网卡上的同一连接应分别统计，共 3 条记录，实际 %d", len(stats))
+	}
+	ifaces := e.GetInterfaceStats()
+	if len(ifaces) != 2 || ifaces[0].Interface != "eth0" || ifaces[1].Interface != "eth1" {
+		t.Fatalf("应按网卡名排序汇总为 eth0、eth1，实际 %+v", ifaces)
+	}
+	if ifaces[0].FlowCount != 2 || ifaces[0].PacketsSent != 2 {
+		t.Fatalf("eth0 应有 2 条连接 2 个数据包，实际 %+v", ifaces[0])
+	}
+	if want := uint64(len(eth1[0].data)); ifaces[1].FlowCount != 1 || ifaces[1].BytesSent != want {
+		t.Fatalf("eth1 应有 1 条连接 %d 字节，实际 %+v", want, ifaces[1])
+	}
+}
+
+// 添加测试：网卡名列表去掉空白和重复项
+func TestResolveDevNames(t *testing.T) {
+	names, err := resolveDevNames([]string{" eth0", "eth1", "", "eth0 "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 || names[0] != "eth0" || names[1] != "eth1" {
+		t.Fatalf("应为 [eth0 eth1]，实际 %v", names)
+	}
+}
+
+// fakeInactiveHandle 记录激活前设置的抓包参数。bufferSize 为 -1 表示未设置
+type fakeInactiveHandle struct {
+	snapLen    int
+	promisc    bool
+	timeout    time.Duration
+	bufferSize int
+	immediate  bool
+	filter     string
+}
+
+func (h *fakeInactiveHandle) SetSnapLen(snaplen int) error     { h.snapLen = snaplen; return nil }
+func (h *fakeInactiveHandle) SetPromisc(promisc bool) error    { h.promisc = promisc; return nil }
+func (h *fakeInactiveHandle) SetTimeout(t time.Duration) error { h.timeout = t; return nil }
+func (h *fakeInactiveHandle) SetBufferSize(size int) error     { h.bufferSize = size; return nil }
+func (h *fakeInactiveHandle) SetImmediateMode(mode bool) error { h.immediate = mode; return nil }
+
+// fakeCaptureHandle 从离线抓包文件读取数据包的网卡句柄
+type fakeCaptureHandle struct {
+	*pcapFileReader
+	inactive *fakeInactiveHandle
+}
+
+func (h *fakeCaptureHandle) SetBPFFilter(expr string) error {
+	h.inactive.filter = expr
+	return nil
+}
+
+// 添加测试：抓包参数经 openDevice 设置到抓包句柄上，并从该句柄抓包
+func TestCaptureOptionsReachHandle(t *testing.T) {
+	file := writeTestPcap(t, []testPacket{
+		{ts: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC), data: newTCPPacket(t, "192.168.1.10", "93.184.216.34", 50000, 443, nil, []byte("request"))},
+	}, false)
+	cases := []struct {
+		opts CaptureOptions
+		want fakeInactiveHandle
+	}{
+		// 零值的抓包长度和超时使用默认值，未设置缓冲区大小时不调用 SetBufferSize
+		{CaptureOptions{}, fakeInactiveHandle{snapLen: DEFAULT_SNAP_LEN, timeout: pcap.BlockForever, bufferSize: -1}},
+		{
+			CaptureOptions{SnapLen: 96, Promiscuous: true, BufferSize: 8 << 20, Timeout: 200 * time.Millisecond, ImmediateMode: true},
+			fakeInactiveHandle{snapLen: 96, promisc: true, timeout: 200 * time.Millisecond, bufferSize: 8 << 20, immediate: true},
+		},
+	}
+	for _, c := range cases {
+		var handle *fakeInactiveHandle
+		e := NewEngine(WithProcessResolver(newMockResolver()), WithLocalIPs(net.ParseIP("192.168.1.10")), WithCaptureOptions(c.opts))
+		e.openCapture = func(devName string, opts CaptureOptions) (captureHandle, error) {
+			handle = &fakeInactiveHandle{bufferSize: -1}
+			if err := applyCaptureOptions(handle, opts); err != nil {
+				return nil, err
+			}
+			reader, err := openPcapFile(file)
+			if err != nil {
+				return nil, err
+			}
+			return &fakeCaptureHandle{pcapFileReader: reader, inactive: handle}, nil
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done, err := e.beginRun(cancel, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		src, err := e.openDevice("eth0", "tcp port 443")
+		if err != nil {
+			t.Fatalf("打开网卡失败: %v", err)
+		}
+		e.capture(ctx, captureSource{iface: "eth0", reader: src})
+		src.Close()
+		e.endRun(done)
+		cancel()
+
+		c.want.filter = "tcp port 443"
+		if *handle != c.want {
+			t.Fatalf("抓包参数 %+v 设置到句柄上应为 %+v，实际 %+v", c.opts, c.want, *handle)
+		}
+		stats := e.GetTrafficStats()
+		if len(stats) != 1 || stats[0].Interface != "eth0" {
+			t.Fatalf("应从打开的句柄抓到 1 条 eth0 的记录，实际 %+v", stats)
+		}
+	}
+}
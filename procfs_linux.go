@@ -0,0 +1,303 @@
+//go:build linux
+// +build linux
+
+package netguard
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 两次全量扫描 /proc/*/fd 的最小间隔，避免找不到进程的套接字（如内核套接字）反复触发全量扫描
+const procfsFullScanInterval = time.Second
+
+// ProcfsResolver 通过 /proc/net/{tcp,tcp6,udp,udp6} 找到套接字的 inode，
+// 再通过 /proc/<pid>/fd 找到打开该 inode 的进程。
+type ProcfsResolver struct {
+	root  string
+	index *socketIndex
+}
+
+// socketIndex 套接字 inode 与进程的对应关系。
+// 关系会被缓存：新连接先只扫描新出现的进程，找不到时才全量扫描 /proc/*/fd。
+type socketIndex struct {
+	root string
+
+	mu           sync.Mutex
+	inodes       map[uint64]socketOwner // 套接字 inode 对应的进程
+	pids         map[int32]struct{}     // 已扫描过的进程
+	lastFullScan time.Time
+	users        map[uint32]string // UID 对应的用户名
+}
+
+// socketOwner 打开套接字的进程，以及对应的文件描述符，用于校验缓存是否仍然有效
+type socketOwner struct {
+	pid int32
+	fd  string
+}
+
+// procNetEntry /proc/net/{tcp,udp}* 中的一行
+type procNetEntry struct {
+	localIP   net.IP
+	localPort uint16
+	uid       uint32
+	inode     uint64
+}
+
+// NewProcfsResolver 创建基于 procfs 的进程查找器。root 为 procfs 的挂载目录，为空时使用 /proc
+func NewProcfsResolver(root string) *ProcfsResolver {
+	if root == "" {
+		root = "/proc"
+	}
+	return &ProcfsResolver{root: root, index: newSocketIndex(root)}
+}
+
+func (r *ProcfsResolver) procfsRoot() string {
+	return r.root
+}
+
+func newSocketIndex(root string) *socketIndex {
+	return &socketIndex{
+		root:   root,
+		inodes: make(map[uint64]socketOwner),
+		pids:   make(map[int32]struct{}),
+		users:  make(map[uint32]string),
+	}
+}
+
+// Lookup 查找拥有本地套接字的进程
+func (r *ProcfsResolver) Lookup(protocol string, localIP net.IP, localPort uint16) (ProcessInfo, error) {
+	entry, err := r.findSocket(protocol, localIP, localPort)
+	if err != nil {
+		return ProcessInfo{}, err
+	}
+	return r.index.lookup(entry.inode, entry.uid)
+}
+
+// findSocket 在 /proc/net 中查找本地地址对应的套接字。
+// 优先精确匹配本地IP，其次匹配监听在 0.0.0.0 或 :: 上的套接字
+func (r *ProcfsResolver) findSocket(protocol string, localIP net.IP, localPort uint16) (procNetEntry, error) {
+	var files []string
+	switch protocol {
+	case "TCP":
+		files = []string{"tcp", "tcp6"}
+	case "UDP":
+		files = []string{"udp", "udp6"}
+	default:
+		return procNetEntry{}, fmt.Errorf("不支持的协议: %s", protocol)
+	}
+	var wildcard *procNetEntry
+	for _, name := range files {
+		entries, err := parseProcNet(filepath.Join(r.root, "net", name))
+		if err != nil {
+			// 未启用IPv6时没有 tcp6/udp6 文件
+			if os.IsNotExist(err) {
+				continue
+			}
+			return procNetEntry{}, err
+		}
+		for i := range entries {
+			entry := &entries[i]
+			// TIME_WAIT 等状态的套接字已不属于任何进程，inode 为0
+			if entry.localPort != localPort || entry.inode == 0 {
+				continue
+			}
+			if entry.localIP.Equal(localIP) {
+				return *entry, nil
+			}
+			if entry.localIP.IsUnspecified() && wildcard == nil {
+				wildcard = entry
+			}
+		}
+	}
+	if wildcard != nil {
+		return *wildcard, nil
+	}
+	return procNetEntry{}, ErrProcessNotFound
+}
+
+// lookup 查找打开了套接字 inode 的进程。uid 为套接字所属的用户。
+// 没有权限读取其他用户进程的 fd 时找不到进程，仍返回套接字所属的用户
+func (r *socketIndex) lookup(inode uint64, uid uint32) (ProcessInfo, error) {
+	r.mu.Lock()
+	pid := r.findInode(inode)
+	username := r.userName(uid)
+	r.mu.Unlock()
+	if pid == 0 {
+		return ProcessInfo{User: username}, ErrProcessNotFound
+	}
+	dir := filepath.Join(r.root, strconv.Itoa(int(pid)))
+	name, _ := os.ReadFile(filepath.Join(dir, "comm"))
+	// 没有权限时读取失败，路径为空
+	exe, _ := os.Readlink(filepath.Join(dir, "exe"))
+	return ProcessInfo{PID: pid, Name: strings.TrimSpace(string(name)), Exe: exe, User: username}, nil
+}
+
+// userName 查询UID对应的用户名，查询不到时返回UID。调用方需持有锁
+func (r *socketIndex) userName(uid uint32) string {
+	if name, ok := r.users[uid]; ok {
+		return name
+	}
+	name := strconv.FormatUint(uint64(uid), 10)
+	if u, err := user.LookupId(name); err == nil {
+		name = u.Username
+	}
+	r.users[uid] = name
+	return name
+}
+
+// findInode 查找打开了套接字 inode 的进程，找不到返回0。调用方需持有锁
+func (r *socketIndex) findInode(inode uint64) int32 {
+	if owner, ok := r.inodes[inode]; ok {
+		// 进程可能已关闭该套接字，inode 被复用
+		link, err := os.Readlink(filepath.Join(r.root, strconv.Itoa(int(owner.pid)), "fd", owner.fd))
+		if err == nil && link == socketLink(inode) {
+			return owner.pid
+		}
+		delete(r.inodes, inode)
+	}
+
+	pids, err := r.listPids()
+	if err != nil {
+		return 0
+	}
+	// 新连接大多属于新启动的进程，先只扫描新出现的进程
+	current := make(map[int32]struct{}, len(pids))
+	for _, pid := range pids {
+		current[pid] = struct{}{}
+		if _, ok := r.pids[pid]; !ok {
+			r.scanPid(pid)
+		}
+	}
+	r.pids = current
+	for k, owner := range r.inodes {
+		if _, ok := current[owner.pid]; !ok {
+			delete(r.inodes, k)
+		}
+	}
+	if owner, ok := r.inodes[inode]; ok {
+		return owner.pid
+	}
+
+	// 已有进程新建的套接字，需要全量扫描
+	if time.Since(r.lastFullScan) < procfsFullScanInterval {
+		return 0
+	}
+	r.lastFullScan = time.Now()
+	for _, pid := range pids {
+		r.scanPid(pid)
+	}
+	if owner, ok := r.inodes[inode]; ok {
+		return owner.pid
+	}
+	return 0
+}
+
+// listPids 列出 procfs 中的所有进程
+func (r *socketIndex) listPids() ([]int32, error) {
+	entries, err := os.ReadDir(r.root)
+	if err != nil {
+		return nil, err
+	}
+	var pids []int32
+	for _, entry := range entries {
+		pid, err := strconv.ParseInt(entry.Name(), 10, 32)
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+		pids = append(pids, int32(pid))
+	}
+	return pids, nil
+}
+
+// scanPid 记录进程打开的所有套接字。没有权限读取的进程直接跳过
+func (r *socketIndex) scanPid(pid int32) {
+	fdDir := filepath.Join(r.root, strconv.Itoa(int(pid)), "fd")
+	fds, err := os.ReadDir(fdDir)
+	if err != nil {
+		return
+	}
+	for _, fd := range fds {
+		link, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+		if err != nil || !strings.HasPrefix(link, "socket:[") {
+			continue
+		}
+		inode, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]"), 10, 64)
+		if err != nil {
+			continue
+		}
+		r.inodes[inode] = socketOwner{pid: pid, fd: fd.Name()}
+	}
+}
+
+func socketLink(inode uint64) string {
+	return "socket:[" + strconv.FormatUint(inode, 10) + "]"
+}
+
+// parseProcNet 解析 /proc/net/{tcp,tcp6,udp,udp6} 文件
+//
+//	sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+//	 0: 0100007F:0035 00000000:0000 0A 00000000:00000000 00:00000000 00000000   101        0 23814 ...
+func parseProcNet(file string) ([]procNetEntry, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []procNetEntry
+	scanner := bufio.NewScanner(f)
+	// 跳过表头
+	scanner.Scan()
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 {
+			continue
+		}
+		ip, port, err := parseProcNetAddr(fields[1])
+		if err != nil {
+			continue
+		}
+		uid, err := strconv.ParseUint(fields[7], 10, 32)
+		if err != nil {
+			continue
+		}
+		inode, err := strconv.ParseUint(fields[9], 10, 64)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, procNetEntry{localIP: ip, localPort: port, uid: uint32(uid), inode: inode})
+	}
+	return entries, scanner.Err()
+}
+
+// parseProcNetAddr 解析 "0100007F:0035" 格式的地址。
+// IP按4字节一组以主机字节序输出，端口为大端的十六进制
+func parseProcNetAddr(s string) (net.IP, uint16, error) {
+	host, portHex, ok := strings.Cut(s, ":")
+	if !ok {
+		return nil, 0, fmt.Errorf("无效的地址: %s", s)
+	}
+	b, err := hex.DecodeString(host)
+	if err != nil || (len(b) != net.IPv4len && len(b) != net.IPv6len) {
+		return nil, 0, fmt.Errorf("无效的地址: %s", s)
+	}
+	ip := make(net.IP, len(b))
+	for i := 0; i < len(b); i += 4 {
+		binary.BigEndian.PutUint32(ip[i:], binary.NativeEndian.Uint32(b[i:]))
+	}
+	port, err := strconv.ParseUint(portHex, 16, 16)
+	if err != nil {
+		return nil, 0, fmt.Errorf("无效的端口: %s", s)
+	}
+	return ip, uint16(port), nil
+}
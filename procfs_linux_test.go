@@ -0,0 +1,310 @@
+//go:build linux
+// +build linux
+
+package netguard
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// fakeProcfs 在临时目录中构造 procfs 的 net 和 <pid>/fd 结构
+type fakeProcfs struct {
+	t    *testing.T
+	root string
+}
+
+func newFakeProcfs(t *testing.T) *fakeProcfs {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "net"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return &fakeProcfs{t: t, root: root}
+}
+
+// procNetAddr 按 /proc/net 的格式编码地址：IP每4字节一组以主机字节序输出。
+// IPv4地址编码为4字节，tcp6/udp6 中的IPv4映射地址需传入16字节的IP
+func procNetAddr(ip net.IP, port uint16) string {
+	b := make([]byte, len(ip))
+	for i := 0; i < len(ip); i += 4 {
+		binary.NativeEndian.PutUint32(b[i:], binary.BigEndian.Uint32(ip[i:]))
+	}
+	return fmt.Sprintf("%s:%04X", hex.EncodeToString(b), port)
+}
+
+// writeNet 写入 /proc/net/<name>，每个 entry 为 本地IP、端口、inode
+func (p *fakeProcfs) writeNet(name string, entries ...procNetEntry) {
+	content := "  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode\n"
+	for i, e := range entries {
+		content += fmt.Sprintf("%4d: %s 00000000:0000 0A 00000000:00000000 00:00000000 00000000 %5d        0 %d 1 0000000000000000 100 0 0 10 0\n",
+			i, procNetAddr(procNetIP(name, e.localIP), e.localPort), e.uid, e.inode)
+	}
+	if err := os.WriteFile(filepath.Join(p.root, "net", name), []byte(content), 0644); err != nil {
+		p.t.Fatal(err)
+	}
+}
+
+// procNetIP tcp/udp 中为4字节地址，tcp6/udp6 中为16字节地址
+func procNetIP(name string, ip net.IP) net.IP {
+	if name == "tcp" || name == "udp" {
+		return ip.To4()
+	}
+	return ip.To16()
+}
+
+// addProcess 创建进程目录，fd 为 fd编号 到 套接字inode 的映射
+func (p *fakeProcfs) addProcess(pid int, comm string, fds map[int]uint64) {
+	dir := filepath.Join(p.root, strconv.Itoa(pid))
+	if err := os.MkdirAll(filepath.Join(dir, "fd"), 0755); err != nil {
+		p.t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "comm"), []byte(comm+"\n"), 0644); err != nil {
+		p.t.Fatal(err)
+	}
+	for fd, inode := range fds {
+		p.setFd(pid, fd, socketLink(inode))
+	}
+}
+
+// writeProcessMeta 写入进程的 stat、status 和 cmdline，供进程信息缓存读取
+func (p *fakeProcfs) writeProcessMeta(pid, ppid int, name string, args ...string) {
+	dir := filepath.Join(p.root, strconv.Itoa(pid))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		p.t.Fatal(err)
+	}
+	files := map[string]string{
+		"stat":    fmt.Sprintf("%d (%s) S %d %d %d 0 -1 4194560 100 0 0 0 1 1 0 0 20 0 1 0 1000 1000000 100 18446744073709551615 0 0 0 0 0 0 0 0 0 0 0 0 17 0 0 0 0 0 0\n", pid, name, ppid, pid, pid),
+		"status":  fmt.Sprintf("Name:\t%s\nPid:\t%d\nPPid:\t%d\nUid:\t0\t0\t0\t0\n", name, pid, ppid),
+		"cmdline": strings.Join(args, "\x00") + "\x00",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			p.t.Fatal(err)
+		}
+	}
+}
+
+func (p *fakeProcfs) setFd(pid, fd int, target string) {
+	link := filepath.Join(p.root, strconv.Itoa(pid), "fd", strconv.Itoa(fd))
+	os.Remove(link)
+	if err := os.Symlink(target, link); err != nil {
+		p.t.Fatal(err)
+	}
+}
+
+// 添加测试：解析 /proc/net 的IPv4和IPv6地址
+func TestParseProcNetAddr(t *testing.T) {
+	for _, tt := range []struct {
+		ip   net.IP
+		port uint16
+	}{
+		{net.ParseIP("127.0.0.1").To4(), 53},
+		{net.ParseIP("192.168.1.10").To4(), 50000},
+		{net.ParseIP("fd00::10"), 443},
+		// tcp6 中的IPv4映射地址
+		{net.ParseIP("::ffff:10.0.0.5").To16(), 8080},
+	} {
+		addr := procNetAddr(tt.ip, tt.port)
+		gotIP, gotPort, err := parseProcNetAddr(addr)
+		if err != nil {
+			t.Fatalf("解析 %s 失败: %v", addr, err)
+		}
+		if !gotIP.Equal(tt.ip) || gotPort != tt.port {
+			t.Fatalf("解析 %s 应为 %s:%d，实际 %s:%d", addr, tt.ip, tt.port, gotIP, gotPort)
+		}
+	}
+	if _, _, err := parseProcNetAddr("0100007F"); err == nil {
+		t.Fatal("缺少端口的地址应解析失败")
+	}
+}
+
+// 添加测试：通过伪造的 procfs 查找套接字所属进程，包括监听地址匹配、新进程增量扫描和 inode 复用
+func TestProcfsResolver(t *testing.T) {
+	p := newFakeProcfs(t)
+	p.writeNet("tcp",
+		procNetEntry{localIP: net.ParseIP("192.168.1.10"), localPort: 50000, inode: 1001},
+		procNetEntry{localIP: net.IPv4zero, localPort: 22, inode: 1002},
+	)
+	p.writeNet("udp", procNetEntry{localIP: net.ParseIP("192.168.1.10"), localPort: 5353, inode: 2001})
+	p.writeNet("tcp6", procNetEntry{localIP: net.ParseIP("fd00::10"), localPort: 443, inode: 3001})
+	p.addProcess(100, "curl", map[int]uint64{3: 1001})
+	p.addProcess(200, "sshd", map[int]uint64{3: 1002, 4: 3001})
+	p.addProcess(300, "avahi", map[int]uint64{5: 2001})
+
+	r := NewProcfsResolver(p.root)
+	lookup := func(protocol, ip string, port uint16) ProcessInfo {
+		t.Helper()
+		info, err := r.Lookup(protocol, net.ParseIP(ip), port)
+		if err != nil {
+			t.Fatalf("查找 %s %s:%d 失败: %v", protocol, ip, port, err)
+		}
+		return info
+	}
+	if info := lookup("TCP", "192.168.1.10", 50000); info.PID != 100 || info.Name != "curl" {
+		t.Fatalf("TCP 连接应属于 100 curl，实际 %+v", info)
+	}
+	// 监听在 0.0.0.0 的套接字接受的连接
+	if info := lookup("TCP", "192.168.1.10", 22); info.PID != 200 {
+		t.Fatalf("监听套接字应属于 200，实际 %+v", info)
+	}
+	if info := lookup("UDP", "192.168.1.10", 5353); info.PID != 300 {
+		t.Fatalf("UDP 套接字应属于 300，实际 %+v", info)
+	}
+	if info := lookup("TCP", "fd00::10", 443); info.PID != 200 {
+		t.Fatalf("IPv6 套接字应属于 200，实际 %+v", info)
+	}
+	if _, err := r.Lookup("UDP", net.ParseIP("192.168.1.10"), 9999); err != ErrProcessNotFound {
+		t.Fatalf("不存在的套接字应返回 ErrProcessNotFound，实际 %v", err)
+	}
+
+	// 没有权限读取其他用户进程的 fd 时，仍能得到套接字所属的用户
+	p.writeNet("udp",
+		procNetEntry{localIP: net.ParseIP("192.168.1.10"), localPort: 5353, inode: 2001},
+		procNetEntry{localIP: net.ParseIP("192.168.1.10"), localPort: 6000, uid: 4242, inode: 2002},
+	)
+	r.index.users[4242] = "bob"
+	if info, err := r.Lookup("UDP", net.ParseIP("192.168.1.10"), 6000); err != ErrProcessNotFound || info.User != "bob" {
+		t.Fatalf("找不到进程时应返回套接字所属用户，实际 %+v %v", info, err)
+	}
+
+	// 新启动的进程不需要等待全量扫描间隔
+	p.writeNet("tcp",
+		procNetEntry{localIP: net.ParseIP("192.168.1.10"), localPort: 50000, inode: 1001},
+		procNetEntry{localIP: net.ParseIP("192.168.1.10"), localPort: 50001, inode: 1003},
+	)
+	p.addProcess(400, "wget", map[int]uint64{3: 1003})
+	if info := lookup("TCP", "192.168.1.10", 50001); info.PID != 400 {
+		t.Fatalf("新进程的套接字应属于 400，实际 %+v", info)
+	}
+
+	// curl 退出后 inode 被其他进程复用，缓存失效
+	os.RemoveAll(filepath.Join(p.root, "100"))
+	p.setFd(400, 7, socketLink(1001))
+	r.index.lastFullScan = r.index.lastFullScan.Add(-procfsFullScanInterval)
+	if info := lookup("TCP", "192.168.1.10", 50000); info.PID != 400 {
+		t.Fatalf("inode 复用后应属于 400，实际 %+v", info)
+	}
+}
+
+// 添加测试：设置 ProcessResolver 后，新建连接使用它查找进程
+func TestEngineWithProcessResolver(t *testing.T) {
+	p := newFakeProcfs(t)
+	p.writeNet("udp", procNetEntry{localIP: net.ParseIP("10.0.0.5"), localPort: 54321, inode: 42})
+	p.addProcess(1234, "dig", map[int]uint64{3: 42})
+
+	e := NewEngine(WithProcessResolver(NewProcfsResolver(p.root)))
+	e.updatePacketRecord(&packetInfo{
+		localIP:    net.IPv4(10, 0, 0, 5),
+		localPort:  54321,
+		remoteIP:   net.IPv4(8, 8, 8, 8),
+		remotePort: 53,
+		protocol:   "UDP",
+		length:     60,
+	})
+	stats := e.GetTrafficStats()
+	if len(stats) != 1 || stats[0].ProcessPID != 1234 || stats[0].ProcessName != "dig" {
+		t.Fatalf("新建连接应通过 ProcessResolver 识别为 1234 dig，实际 %+v", stats)
+	}
+}
+
+// 添加测试：进程的命令行和父进程链从 ProcessResolver 的 procfs 目录读取，而不是本机的 /proc
+func TestProcessMetaFromResolverRoot(t *testing.T) {
+	p := newFakeProcfs(t)
+	p.writeNet("udp", procNetEntry{localIP: net.ParseIP("10.0.0.5"), localPort: 54321, inode: 42})
+	p.addProcess(1234, "dig", map[int]uint64{3: 42})
+	p.writeProcessMeta(1234, 1000, "dig", "dig", "example.com")
+	p.writeProcessMeta(1000, 1, "bash", "-bash")
+
+	e := NewEngine(WithProcessResolver(NewProcfsResolver(p.root)))
+	e.updatePacketRecord(&packetInfo{
+		localIP:    net.IPv4(10, 0, 0, 5),
+		localPort:  54321,
+		remoteIP:   net.IPv4(8, 8, 8, 8),
+		remotePort: 53,
+		protocol:   "UDP",
+		length:     60,
+	})
+	stats := e.GetTrafficStats()
+	if len(stats) != 1 {
+		t.Fatalf("应有1条连接，实际 %d", len(stats))
+	}
+	tr := stats[0]
+	if tr.ProcessCmdline != "dig example.com" || tr.ProcessPPID != 1000 {
+		t.Fatalf("命令行和父进程应来自伪造的 procfs，实际 %q %d", tr.ProcessCmdline, tr.ProcessPPID)
+	}
+	// PID 1 不在伪造的 procfs 中，父进程链到 bash 为止
+	if len(tr.ParentChain) != 1 || tr.ParentChain[0].Name != "bash" {
+		t.Fatalf("父进程链应只有 bash，实际 %+v", tr.ParentChain)
+	}
+	if meta, ok := e.GetProcessMeta(1000); !ok || meta.Cmdline != "-bash" {
+		t.Fatalf("GetProcessMeta 应读取伪造的 procfs，实际 %+v %v", meta, ok)
+	}
+}
+
+// 添加测试：容器ID从进程信息缓存的 procfs 目录读取
+func TestProcessMetaContainerIDFromRoot(t *testing.T) {
+	p := newFakeProcfs(t)
+	p.writeProcessMeta(1234, 1, "nginx", "nginx")
+	const id = "3f4e8b0c1d2a5f6e7b8c9d0a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b0c"
+	if err := os.WriteFile(filepath.Join(p.root, "1234", "cgroup"), []byte("0::/docker/"+id+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	meta, ok := newProcessMetaCache(p.root).get(1234)
+	if !ok || meta.ContainerID != id {
+		t.Fatalf("容器ID应为 %s，实际 %+v %v", id, meta, ok)
+	}
+	if root := resolverProcfsRoot(NewNetlinkResolver(p.root)); root != p.root {
+		t.Fatalf("NetlinkResolver 应使用指定的 procfs 目录，实际 %q", root)
+	}
+}
+
+// 添加测试：NetlinkResolver 向内核查询本进程监听的TCP端口和UDP端口
+func TestNetlinkResolverLookup(t *testing.T) {
+	if _, err := sockDiagDump(syscall.AF_INET, syscall.IPPROTO_TCP, 0); err != nil {
+		t.Skipf("当前环境不支持 NETLINK_SOCK_DIAG: %v", err)
+	}
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	udp, err := net.ListenPacket("udp4", "0.0.0.0:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+
+	// 内核按本地端口过滤，只返回监听端口的套接字
+	port := uint16(ln.Addr().(*net.TCPAddr).Port)
+	entries, err := sockDiagDump(syscall.AF_INET, syscall.IPPROTO_TCP, port)
+	if err != nil || len(entries) == 0 {
+		t.Fatalf("应查询到监听端口 %d 的套接字，实际 %v %v", port, entries, err)
+	}
+	for _, entry := range entries {
+		if entry.localPort != port {
+			t.Fatalf("只应返回本地端口为 %d 的套接字，实际 %+v", port, entry)
+		}
+	}
+
+	r := NewNetlinkResolver("")
+	info, err := r.Lookup("TCP", net.IPv4(127, 0, 0, 1), port)
+	if err != nil || info.PID != int32(os.Getpid()) {
+		t.Fatalf("TCP 监听端口应属于当前进程 %d，实际 %+v %v", os.Getpid(), info, err)
+	}
+	if info.User == "" {
+		t.Fatal("应返回进程所属用户")
+	}
+	// 监听在 0.0.0.0 的UDP套接字
+	info, err = r.Lookup("UDP", net.IPv4(127, 0, 0, 1), uint16(udp.LocalAddr().(*net.UDPAddr).Port))
+	if err != nil || info.PID != int32(os.Getpid()) {
+		t.Fatalf("UDP 端口应属于当前进程 %d，实际 %+v %v", os.Getpid(), info, err)
+	}
+}
@@ -0,0 +1,148 @@
+package netguard
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/common"
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+const (
+	// 缓存的进程信息超过该时长后，使用前重新检查进程创建时间，判断PID是否被复用
+	processMetaRecheck = 10 * time.Second
+	// 超过该时长未使用的缓存会被删除
+	processMetaIdle = 10 * time.Minute
+)
+
+// ProcessMeta 进程的详细信息
+type ProcessMeta struct {
+	PID         int32
+	PPID        int32 // 父进程PID
+	Name        string
+	Exe         string // 可执行文件路径。无权限时为空
+	Cmdline     string // 命令行。无权限时为空
+	User        string // 进程所属用户名
+	CreateTime  time.Time
+	ContainerID string // 进程所在容器的ID，不在容器中时为空。仅 Linux
+}
+
+// processMetaEntry 缓存项。checked 为最近一次检查进程创建时间的时间
+type processMetaEntry struct {
+	meta     ProcessMeta
+	checked  time.Time
+	lastUsed time.Time
+}
+
+// processMetaCache 进程信息缓存。每个引擎一个，与进程查找器从同一个 procfs 目录读取进程信息
+type processMetaCache struct {
+	root string          // procfs 的挂载目录，为空时读取本机进程
+	ctx  context.Context // 传给 gopsutil，使其从 root 读取进程信息
+
+	mu        sync.Mutex
+	entries   map[int32]*processMetaEntry
+	lastPrune time.Time
+}
+
+// newProcessMetaCache 创建进程信息缓存。root 为 procfs 的挂载目录，为空时读取本机进程
+func newProcessMetaCache(root string) *processMetaCache {
+	ctx := context.Background()
+	if root != "" {
+		ctx = context.WithValue(ctx, common.EnvKey, common.EnvMap{common.HostProcEnvKey: root})
+	}
+	return &processMetaCache{
+		root:    root,
+		ctx:     ctx,
+		entries: make(map[int32]*processMetaEntry),
+	}
+}
+
+// GetProcessMeta 获取默认引擎中进程的详细信息
+func GetProcessMeta(pid int32) (ProcessMeta, bool) {
+	return DefaultEngine().GetProcessMeta(pid)
+}
+
+// GetProcessMeta 获取进程的详细信息，从进程查找器使用的 procfs 目录读取。
+// 结果会被缓存，PID被新进程复用时自动更新。进程不存在时返回 false
+func (e *Engine) GetProcessMeta(pid int32) (ProcessMeta, bool) {
+	return e.processMetas.get(pid)
+}
+
+func (c *processMetaCache) get(pid int32) (ProcessMeta, bool) {
+	if pid <= 0 {
+		return ProcessMeta{}, false
+	}
+	now := time.Now()
+	c.mu.Lock()
+	c.prune(now)
+	entry, ok := c.entries[pid]
+	if ok {
+		entry.lastUsed = now
+		if now.Sub(entry.checked) < processMetaRecheck {
+			meta := entry.meta
+			c.mu.Unlock()
+			return meta, true
+		}
+	}
+	c.mu.Unlock()
+
+	// 读取进程信息时不持有锁。不使用 process.NewProcess：procfs 目录不是挂载点时，
+	// 它会向本机同PID的进程发信号来判断进程是否存在
+	proc := &process.Process{Pid: pid}
+	createMs, err := proc.CreateTimeWithContext(c.ctx)
+	if err != nil {
+		c.mu.Lock()
+		delete(c.entries, pid)
+		c.mu.Unlock()
+		return ProcessMeta{}, false
+	}
+	createTime := time.UnixMilli(createMs)
+	var meta ProcessMeta
+	if ok && entry.meta.CreateTime.Equal(createTime) {
+		// 仍是同一个进程
+		c.mu.Lock()
+		meta = entry.meta
+		entry.checked = now
+		c.mu.Unlock()
+		return meta, true
+	}
+	// 新进程，或PID已被新进程复用
+	meta = c.load(proc, createTime)
+	c.mu.Lock()
+	c.entries[pid] = &processMetaEntry{meta: meta, checked: now, lastUsed: now}
+	c.mu.Unlock()
+	return meta, true
+}
+
+// prune 删除长时间未使用的缓存。调用方需持有锁
+func (c *processMetaCache) prune(now time.Time) {
+	if now.Sub(c.lastPrune) < time.Minute {
+		return
+	}
+	c.lastPrune = now
+	for pid, entry := range c.entries {
+		if now.Sub(entry.lastUsed) > processMetaIdle {
+			delete(c.entries, pid)
+		}
+	}
+}
+
+// load 读取进程信息，读取失败的字段为空
+func (c *processMetaCache) load(proc *process.Process, createTime time.Time) ProcessMeta {
+	meta := ProcessMeta{PID: proc.Pid, CreateTime: createTime}
+	meta.Name, _ = proc.NameWithContext(c.ctx)
+	meta.Exe, _ = proc.ExeWithContext(c.ctx)
+	if args, err := proc.CmdlineSliceWithContext(c.ctx); err == nil {
+		meta.Cmdline = strings.Join(args, " ")
+	}
+	meta.User, _ = proc.UsernameWithContext(c.ctx)
+	meta.PPID, _ = proc.PpidWithContext(c.ctx)
+	root := c.root
+	if root == "" {
+		root = "/proc"
+	}
+	meta.ContainerID = readContainerID(root, proc.Pid)
+	return meta
+}
@@ -0,0 +1,46 @@
+package netguard
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+// 添加测试：缓存当前进程的信息，PID被复用（创建时间变化）时重新读取，进程退出后返回 false
+func TestProcessMetaCache(t *testing.T) {
+	c := newProcessMetaCache("")
+	pid := int32(os.Getpid())
+	meta, ok := c.get(pid)
+	if !ok {
+		t.Fatal("应能获取当前进程的信息")
+	}
+	if meta.Name == "" || meta.Cmdline == "" || meta.CreateTime.IsZero() || meta.PPID != int32(os.Getppid()) {
+		t.Fatalf("进程信息不完整: %+v", meta)
+	}
+
+	// 检查间隔内直接使用缓存
+	c.entries[pid].meta.Name = "cached"
+	if meta, _ = c.get(pid); meta.Name != "cached" {
+		t.Fatalf("检查间隔内应使用缓存，实际 %q", meta.Name)
+	}
+	// 超过检查间隔且创建时间不变，仍使用缓存
+	c.entries[pid].checked = time.Now().Add(-processMetaRecheck)
+	if meta, _ = c.get(pid); meta.Name != "cached" {
+		t.Fatalf("创建时间不变时应使用缓存，实际 %q", meta.Name)
+	}
+	// 创建时间变化说明PID被新进程复用，重新读取
+	c.entries[pid].checked = time.Now().Add(-processMetaRecheck)
+	c.entries[pid].meta.CreateTime = c.entries[pid].meta.CreateTime.Add(-time.Hour)
+	if meta, _ = c.get(pid); meta.Name == "cached" {
+		t.Fatal("PID被复用后应重新读取进程信息")
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Skipf("无法启动子进程: %v", err)
+	}
+	if _, ok = c.get(int32(cmd.Process.Pid)); ok {
+		t.Fatal("已退出的进程应返回 false")
+	}
+}
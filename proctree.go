@@ -0,0 +1,66 @@
+package netguard
+
+import "strings"
+
+// 父进程链的最大长度，防止异常数据导致死循环
+const maxParentChainDepth = 32
+
+// DefaultAppLaunchers 默认的启动器进程名。启动器的直接子进程被视为顶层应用
+var DefaultAppLaunchers = []string{
+	"init", "systemd", "launchd", "sshd",
+	"explorer.exe", "services.exe", "wininit.exe", "svchost.exe",
+}
+
+// ProcessAncestor 父进程链中的一个进程
+type ProcessAncestor struct {
+	PID  int32
+	Name string
+	Exe  string
+}
+
+// WithAppLaunchers 设置启动器进程名（不区分大小写）。
+// 顶层应用为进程向上查找时，启动器或PID 1之下的最上层进程，如浏览器的渲染进程归属于浏览器主进程。默认为 DefaultAppLaunchers
+func WithAppLaunchers(names ...string) Option {
+	return func(e *Engine) {
+		e.appLaunchers = launcherSet(names)
+	}
+}
+
+func launcherSet(names []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		set[strings.ToLower(name)] = struct{}{}
+	}
+	return set
+}
+
+// parentChain 从直接父进程开始，向上获取父进程链
+func (e *Engine) parentChain(ppid int32) []ProcessAncestor {
+	var chain []ProcessAncestor
+	seen := make(map[int32]bool)
+	for ppid > 0 && !seen[ppid] && len(chain) < maxParentChainDepth {
+		seen[ppid] = true
+		meta, ok := e.processMetas.get(ppid)
+		if !ok {
+			break
+		}
+		chain = append(chain, ProcessAncestor{PID: meta.PID, Name: meta.Name, Exe: meta.Exe})
+		ppid = meta.PPID
+	}
+	return chain
+}
+
+// topLevelApp 在进程本身和父进程链中找到顶层应用：父进程为启动器或PID 1的最上层进程
+func (e *Engine) topLevelApp(self ProcessAncestor, chain []ProcessAncestor) ProcessAncestor {
+	app := self
+	for _, parent := range chain {
+		if parent.PID <= 1 {
+			break
+		}
+		if _, ok := e.appLaunchers[strings.ToLower(parent.Name)]; ok {
+			break
+		}
+		app = parent
+	}
+	return app
+}
@@ -0,0 +1,88 @@
+package netguard
+
+import (
+	"net"
+	"os"
+	"testing"
+)
+
+// 添加测试：顶层应用为启动器或PID 1之下的最上层进程
+func TestTopLevelApp(t *testing.T) {
+	e := NewEngine(WithAppLaunchers("systemd", "sshd"))
+	renderer := ProcessAncestor{PID: 300, Name: "chrome"}
+	for _, tt := range []struct {
+		name  string
+		chain []ProcessAncestor
+		want  int32
+	}{
+		{"浏览器子进程归属于浏览器主进程", []ProcessAncestor{{PID: 200, Name: "chrome"}, {PID: 100, Name: "systemd"}, {PID: 1, Name: "systemd"}}, 200},
+		{"脚本启动的curl归属于脚本", []ProcessAncestor{{PID: 200, Name: "bash"}, {PID: 150, Name: "SSHD"}}, 200},
+		{"父进程为PID 1时就是自身", []ProcessAncestor{{PID: 1, Name: "init"}}, 300},
+		{"没有父进程信息时就是自身", nil, 300},
+		{"父进程链未到达启动器时取最上层", []ProcessAncestor{{PID: 200, Name: "bash"}, {PID: 150, Name: "tmux"}}, 150},
+	} {
+		if got := e.topLevelApp(renderer, tt.chain); got.PID != tt.want {
+			t.Errorf("%s: 顶层应用应为 %d，实际 %d", tt.name, tt.want, got.PID)
+		}
+	}
+}
+
+// 添加测试：新建连接时记录父进程链和顶层应用
+func TestProcessParentChain(t *testing.T) {
+	m := newMockResolver()
+	self := int32(os.Getpid())
+	m.set("TCP", net.IPv4(10, 0, 0, 5), 40000, ProcessInfo{PID: self})
+	e := NewEngine(WithProcessResolver(m))
+	e.updatePacketRecord(&packetInfo{
+		localIP:    net.IPv4(10, 0, 0, 5),
+		localPort:  40000,
+		remoteIP:   net.IPv4(1, 1, 1, 1),
+		remotePort: 443,
+		protocol:   "TCP",
+		length:     100,
+	})
+	tr := e.GetTrafficStats()[0]
+	if tr.ProcessPID != self || tr.ProcessPPID != int32(os.Getppid()) {
+		t.Fatalf("进程和父进程PID不正确: %d %d", tr.ProcessPID, tr.ProcessPPID)
+	}
+	if len(tr.ParentChain) == 0 || tr.ParentChain[0].PID != int32(os.Getppid()) {
+		t.Fatalf("父进程链应从直接父进程开始: %+v", tr.ParentChain)
+	}
+	if tr.AppPID == 0 || tr.AppName == "" {
+		t.Fatalf("应确定顶层应用: %d %q", tr.AppPID, tr.AppName)
+	}
+}
+
+// 添加测试：按顶层应用汇总时，同一应用的多个进程合并为一条
+func TestProcessStatsGroupByApp(t *testing.T) {
+	e := NewEngine()
+	add := func(key string, pid int32, name string, appPID int32, appName string, bytes uint64) {
+		e.trafficMap.Store(key, &TrafficRecord{
+			RemoteIP:    net.IPv4(1, 1, 1, 1),
+			ProcessPID:  pid,
+			ProcessName: name,
+			AppPID:      appPID,
+			AppName:     appName,
+			BytesSent:   bytes,
+		})
+	}
+	add("a", 201, "chrome", 200, "chrome", 100)
+	add("b", 202, "chrome", 200, "chrome", 200)
+	add("c", 200, "chrome", 200, "chrome", 50)
+	add("d", 300, "curl", 0, "", 10)
+
+	byProcess := e.GetProcessStatsBy(GroupByProcess)
+	if len(byProcess) != 4 {
+		t.Fatalf("按进程应有 4 条，实际 %d", len(byProcess))
+	}
+	byApp := e.GetProcessStatsBy(GroupByApp)
+	if len(byApp) != 2 {
+		t.Fatalf("按顶层应用应有 2 条，实际 %d", len(byApp))
+	}
+	if byApp[0].ProcessPID != 200 || byApp[0].BytesSent != 350 || byApp[0].ProcessCount != 3 || byApp[0].FlowCount != 3 {
+		t.Fatalf("chrome 的汇总不正确: %+v", byApp[0])
+	}
+	if byApp[1].ProcessPID != 300 || byApp[1].ProcessCount != 1 {
+		t.Fatalf("没有顶层应用信息的连接应按进程本身汇总: %+v", byApp[1])
+	}
+}
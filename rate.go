@@ -0,0 +1,76 @@
+package netguard
+
+import (
+	"math"
+	"time"
+)
+
+// 流量速率的统计窗口
+var rateWindows = [3]time.Duration{time.Second, 10 * time.Second, time.Minute}
+
+// TrafficRate 流量速率（每秒，收发合计）。
+// 分别为1秒、10秒、60秒窗口的指数加权移动平均值（EWMA），窗口越短越接近瞬时值。
+type TrafficRate struct {
+	BytesPerSec1s    float64
+	BytesPerSec10s   float64
+	BytesPerSec60s   float64
+	PacketsPerSec1s  float64
+	PacketsPerSec10s float64
+	PacketsPerSec60s float64
+}
+
+// add 累加另一个速率，用于汇总多个连接
+func (r *TrafficRate) add(o TrafficRate) {
+	r.BytesPerSec1s += o.BytesPerSec1s
+	r.BytesPerSec10s += o.BytesPerSec10s
+	r.BytesPerSec60s += o.BytesPerSec60s
+	r.PacketsPerSec1s += o.PacketsPerSec1s
+	r.PacketsPerSec10s += o.PacketsPerSec10s
+	r.PacketsPerSec60s += o.PacketsPerSec60s
+}
+
+// ewmaRates 按时间衰减的速率计算器。
+// 每来一个数据包：rate = rate * e^(-dt/窗口) + 数据量/窗口。数据量稳定时 rate 收敛于每秒数据量。
+type ewmaRates struct {
+	last    time.Time
+	bytes   [len(rateWindows)]float64
+	packets [len(rateWindows)]float64
+}
+
+// add 记录一个数据包
+func (r *ewmaRates) add(now time.Time, length uint64) {
+	r.decay(now)
+	for i, w := range rateWindows {
+		sec := w.Seconds()
+		r.bytes[i] += float64(length) / sec
+		r.packets[i] += 1 / sec
+	}
+}
+
+// decay 把速率衰减到 now 时刻
+func (r *ewmaRates) decay(now time.Time) {
+	if !r.last.IsZero() && now.After(r.last) {
+		dt := now.Sub(r.last).Seconds()
+		for i, w := range rateWindows {
+			f := math.Exp(-dt / w.Seconds())
+			r.bytes[i] *= f
+			r.packets[i] *= f
+		}
+	}
+	if now.After(r.last) {
+		r.last = now
+	}
+}
+
+// at 返回 now 时刻的速率。不修改内部状态
+func (r ewmaRates) at(now time.Time) TrafficRate {
+	r.decay(now)
+	return TrafficRate{
+		BytesPerSec1s:    r.bytes[0],
+		BytesPerSec10s:   r.bytes[1],
+		BytesPerSec60s:   r.bytes[2],
+		PacketsPerSec1s:  r.packets[0],
+		PacketsPerSec10s: r.packets[1],
+		PacketsPerSec60s: r.packets[2],
+	}
+}
@@ -0,0 +1,81 @@
+package netguard
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// 添加测试：稳定流量下各窗口的速率收敛到实际速率，停止后短窗口先衰减
+func TestEwmaRates(t *testing.T) {
+	var r ewmaRates
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	// 每秒10个包，每个100字节，持续5分钟
+	var now time.Time
+	for i := 0; i < 3000; i++ {
+		now = base.Add(time.Duration(i) * 100 * time.Millisecond)
+		r.add(now, 100)
+	}
+	near := func(got, want float64) bool {
+		return math.Abs(got-want) <= want*0.06
+	}
+	rate := r.at(now)
+	for _, v := range []float64{rate.BytesPerSec1s, rate.BytesPerSec10s, rate.BytesPerSec60s} {
+		if !near(v, 1000) {
+			t.Fatalf("字节速率应接近 1000，实际 %+v", rate)
+		}
+	}
+	for _, v := range []float64{rate.PacketsPerSec1s, rate.PacketsPerSec10s, rate.PacketsPerSec60s} {
+		if !near(v, 10) {
+			t.Fatalf("包速率应接近 10，实际 %+v", rate)
+		}
+	}
+
+	// 停止发送60秒后：1秒窗口接近0，60秒窗口约为原来的 1/e
+	rate = r.at(now.Add(time.Minute))
+	if rate.BytesPerSec1s > 1 {
+		t.Fatalf("1秒窗口应衰减到接近0，实际 %v", rate.BytesPerSec1s)
+	}
+	if !near(rate.BytesPerSec60s, 1000/math.E) {
+		t.Fatalf("60秒窗口应约为 %v，实际 %v", 1000/math.E, rate.BytesPerSec60s)
+	}
+	// at 不修改内部状态
+	if got := r.at(now); !near(got.BytesPerSec1s, 1000) {
+		t.Fatalf("at 不应修改速率，实际 %v", got.BytesPerSec1s)
+	}
+}
+
+// 添加测试：回放后每条连接记录收发包数、首个数据包时间和速率
+func TestTrafficRecordPacketCounts(t *testing.T) {
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	var packets []testPacket
+	for i := 0; i < 4; i++ {
+		ts := base.Add(time.Duration(i) * time.Second)
+		packets = append(packets,
+			testPacket{ts: ts, data: newUDPPacket(t, "192.168.1.10", "8.8.8.8", 53000, 53, []byte("query"))},
+			testPacket{ts: ts.Add(10 * time.Millisecond), data: newUDPPacket(t, "8.8.8.8", "192.168.1.10", 53, 53000, []byte("answer"))},
+		)
+	}
+	packets = append(packets, testPacket{ts: base.Add(5 * time.Second), data: newUDPPacket(t, "192.168.1.10", "8.8.8.8", 53000, 53, []byte("query"))})
+
+	e := replayTestPcap(t, packets, false)
+	stats := e.GetTrafficStats()
+	if len(stats) != 1 {
+		t.Fatalf("应有 1 条流量记录，实际 %d", len(stats))
+	}
+	tr := stats[0]
+	if tr.PacketsSent != 5 || tr.PacketsReceived != 4 {
+		t.Fatalf("发送/接收包数应为 5/4，实际 %d/%d", tr.PacketsSent, tr.PacketsReceived)
+	}
+	if !tr.FirstSeen.Equal(base) || !tr.LastUpdate.Equal(base.Add(5*time.Second)) {
+		t.Fatalf("首个/最后数据包时间不正确: %v %v", tr.FirstSeen, tr.LastUpdate)
+	}
+	// 离线回放的速率取最后一个数据包时刻的值，而不是衰减到当前时间
+	if tr.Rate.PacketsPerSec60s <= 0 || tr.Rate.BytesPerSec60s <= 0 {
+		t.Fatalf("离线回放的速率不应为0: %+v", tr.Rate)
+	}
+	sockets := e.GetSocketStats()
+	if len(sockets) != 1 || sockets[0].PacketsSent != 5 || sockets[0].Rate != tr.Rate {
+		t.Fatalf("套接字汇总的包数和速率不正确: %+v", sockets[0])
+	}
+}
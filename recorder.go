@@ -0,0 +1,231 @@
+package netguard
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/iotames/netguard/log"
+)
+
+const DEFAULT_RECORD_FILE_PREFIX = "netguard"
+
+// RecordOptions 抓包录制配置
+type RecordOptions struct {
+	Dir            string        // 抓包文件保存目录
+	FilePrefix     string        // 文件名前缀，默认 netguard
+	MaxFileSize    int64         // 单个文件的最大字节数，超过后切换新文件。0表示不按大小切换
+	RotateInterval time.Duration // 单个文件的最长录制时长，超过后切换新文件。0表示不按时间切换
+	MaxFiles       int           // 最多保留的文件数，超过后删除最旧的文件。0表示不限制
+}
+
+// PcapRecorder 把抓到的原始数据包滚动保存为 pcapng 文件
+type PcapRecorder struct {
+	opts RecordOptions
+
+	mu        sync.Mutex
+	f         *os.File
+	w         *pcapgo.NgWriter
+	size      int64                   // 当前文件已写入的字节数（含写缓冲中的数据）
+	ifaces    map[recordInterface]int // 当前文件中已登记的网卡及其在文件中的序号
+	fileStart time.Time
+	seq       int
+}
+
+// recordInterface 录制文件中的一个网卡。同时监控多个网卡时，各网卡的链路类型可能不同（如以太网和环回网卡）
+type recordInterface struct {
+	name     string
+	linkType layers.LinkType
+}
+
+// NewPcapRecorder 创建抓包录制器。文件在写入第一个数据包时才创建。
+//
+//	r := netguard.NewPcapRecorder(netguard.RecordOptions{
+//		Dir:            "runtime/pcap",
+//		MaxFileSize:    100 * 1024 * 1024,
+//		RotateInterval: time.Hour,
+//		MaxFiles:       24,
+//	})
+//	e := netguard.NewEngine(netguard.WithRecorder(r))
+func NewPcapRecorder(opts RecordOptions) *PcapRecorder {
+	if opts.FilePrefix == "" {
+		opts.FilePrefix = DEFAULT_RECORD_FILE_PREFIX
+	}
+	return &PcapRecorder{opts: opts}
+}
+
+// WritePacket 写入一个数据包。需要时自动切换到新文件。
+func (r *PcapRecorder) WritePacket(linkType layers.LinkType, ci gopacket.CaptureInfo, data []byte) error {
+	return r.WriteInterfacePacket("", linkType, ci, data)
+}
+
+// WriteInterfacePacket 写入从指定网卡抓到的数据包。
+// 每个网卡在文件中登记为一个 pcapng 接口，链路类型不同的网卡可以写入同一个文件。需要时自动切换到新文件。
+func (r *PcapRecorder) WriteInterfacePacket(iface string, linkType layers.LinkType, ci gopacket.CaptureInfo, data []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.w != nil && r.needRotate() {
+		r.closeFile()
+	}
+	if r.w == nil {
+		if err := r.openFile(iface, linkType); err != nil {
+			return err
+		}
+	}
+	id, err := r.interfaceIndex(iface, linkType)
+	if err != nil {
+		return err
+	}
+	ci.InterfaceIndex = id
+	if err := r.w.WritePacket(ci, data); err != nil {
+		return err
+	}
+	// Enhanced Packet Block 固定占用32字节，数据按4字节对齐
+	r.size += 32 + int64((len(data)+3)&^3)
+	return nil
+}
+
+// Close 写入缓冲区并关闭当前文件。之后再写入会创建新文件。
+func (r *PcapRecorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.closeFile()
+}
+
+// needRotate 当前文件是否需要切换
+func (r *PcapRecorder) needRotate() bool {
+	if r.opts.MaxFileSize > 0 && r.size >= r.opts.MaxFileSize {
+		return true
+	}
+	if r.opts.RotateInterval > 0 && time.Since(r.fileStart) >= r.opts.RotateInterval {
+		return true
+	}
+	return false
+}
+
+// ngInterface 录制文件中网卡的接口描述
+func ngInterface(iface string, linkType layers.LinkType) pcapgo.NgInterface {
+	intf := pcapgo.DefaultNgInterface
+	intf.LinkType = linkType
+	if iface != "" {
+		intf.Name = iface
+	}
+	return intf
+}
+
+// interfaceIndex 返回网卡在当前文件中的序号，第一次出现的网卡登记为新的接口
+func (r *PcapRecorder) interfaceIndex(iface string, linkType layers.LinkType) (int, error) {
+	key := recordInterface{name: iface, linkType: linkType}
+	if id, ok := r.ifaces[key]; ok {
+		return id, nil
+	}
+	id, err := r.w.AddInterface(ngInterface(iface, linkType))
+	if err != nil {
+		return 0, fmt.Errorf("录制文件登记网卡(%s)失败: %w", iface, err)
+	}
+	r.ifaces[key] = id
+	// Interface Description Block 的长度取决于选项，写入后重新统计文件大小
+	if err = r.w.Flush(); err != nil {
+		return 0, err
+	}
+	info, err := r.f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	r.size = info.Size()
+	return id, nil
+}
+
+func (r *PcapRecorder) openFile(iface string, linkType layers.LinkType) error {
+	if err := os.MkdirAll(r.opts.Dir, 0755); err != nil {
+		return fmt.Errorf("创建抓包录制目录(%s)失败: %w", r.opts.Dir, err)
+	}
+	now := time.Now()
+	r.seq++
+	// 文件名以时间开头，按文件名排序即按创建时间排序
+	fname := fmt.Sprintf("%s-%s-%04d.pcapng", r.opts.FilePrefix, now.Format("20060102-150405"), r.seq%10000)
+	fpath := filepath.Join(r.opts.Dir, fname)
+	f, err := os.Create(fpath)
+	if err != nil {
+		return fmt.Errorf("创建抓包录制文件(%s)失败: %w", fpath, err)
+	}
+	w, err := pcapgo.NewNgWriterInterface(f, ngInterface(iface, linkType), pcapgo.DefaultNgWriterOptions)
+	if err == nil {
+		// 立即写入文件头，以便统计文件大小
+		err = w.Flush()
+	}
+	var info os.FileInfo
+	if err == nil {
+		info, err = f.Stat()
+	}
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("写入抓包录制文件(%s)失败: %w", fpath, err)
+	}
+	r.f, r.w, r.size = f, w, info.Size()
+	r.ifaces = map[recordInterface]int{{name: iface, linkType: linkType}: 0}
+	r.fileStart = now
+	log.Info("开始录制抓包文件", "文件", fpath)
+	r.removeOldFiles()
+	return nil
+}
+
+func (r *PcapRecorder) closeFile() error {
+	if r.w == nil {
+		return nil
+	}
+	err := r.w.Flush()
+	if cerr := r.f.Close(); err == nil {
+		err = cerr
+	}
+	r.f, r.w, r.size, r.ifaces = nil, nil, 0, nil
+	return err
+}
+
+// removeOldFiles 删除超过 MaxFiles 数量的最旧的抓包文件
+func (r *PcapRecorder) removeOldFiles() {
+	if r.opts.MaxFiles <= 0 {
+		return
+	}
+	files, err := r.Files()
+	if err != nil {
+		log.Warn("获取抓包录制文件列表失败", "错误", err)
+		return
+	}
+	for i := 0; i < len(files)-r.opts.MaxFiles; i++ {
+		if err = os.Remove(files[i]); err != nil {
+			log.Warn("删除旧的抓包录制文件失败", "错误", err, "文件", files[i])
+		}
+	}
+}
+
+// recordFileSuffix 录制文件名中前缀之后的部分：-时间-序号.pcapng，见 openFile
+var recordFileSuffix = regexp.MustCompile(`^-\d{8}-\d{6}-\d{4}\.pcapng$`)
+
+// Files 按创建时间从旧到新返回录制目录中的抓包文件。
+// 只匹配完整的文件名格式，前缀相同的其他录制器（如前缀为 netguard-eth0）的文件不会被当作自己的文件删除
+func (r *PcapRecorder) Files() ([]string, error) {
+	entries, err := os.ReadDir(r.opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		name := entry.Name()
+		suffix, ok := strings.CutPrefix(name, r.opts.FilePrefix)
+		if entry.IsDir() || !ok || !recordFileSuffix.MatchString(suffix) {
+			continue
+		}
+		files = append(files, filepath.Join(r.opts.Dir, name))
+	}
+	sort.Strings(files)
+	return files, nil
+}
@@ -0,0 +1,134 @@
+package netguard
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// 添加测试：按文件大小切换新文件，并只保留最新的 MaxFiles 个文件
+func TestPcapRecorderRotate(t *testing.T) {
+	dir := t.TempDir()
+	r := NewPcapRecorder(RecordOptions{Dir: dir, MaxFileSize: 1, MaxFiles: 2})
+	data := newUDPPacket(t, "192.168.1.10", "8.8.8.8", 53000, 53, []byte("query"))
+	ts := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		ci := gopacket.CaptureInfo{Timestamp: ts, CaptureLength: len(data), Length: len(data)}
+		if err := r.WritePacket(layers.LinkTypeEthernet, ci, data); err != nil {
+			t.Fatalf("录制数据包失败: %v", err)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatalf("关闭录制文件失败: %v", err)
+	}
+
+	files, err := r.Files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("应保留 2 个录制文件，实际 %d", len(files))
+	}
+
+	// 录制的文件可以被离线回放读取
+	reader, err := openPcapFile(files[len(files)-1])
+	if err != nil {
+		t.Fatalf("读取录制文件失败: %v", err)
+	}
+	defer reader.Close()
+	got, ci, err := reader.ReadPacketData()
+	if err != nil {
+		t.Fatalf("读取数据包失败: %v", err)
+	}
+	if string(got) != string(data) || !ci.Timestamp.Equal(ts) {
+		t.Fatal("录制的数据包内容或时间不匹配")
+	}
+	if _, _, err = reader.ReadPacketData(); err != io.EOF {
+		t.Fatalf("每个文件应只有 1 个数据包，实际读取结果 %v", err)
+	}
+}
+
+// 添加测试：前缀更长的其他录制器的文件不属于本录制器，滚动删除旧文件时保留
+func TestPcapRecorderFilesOtherPrefix(t *testing.T) {
+	dir := t.TempDir()
+	others := []string{"netguard-eth0-20240101-120000-0000.pcapng", "netguard-notes.pcapng"}
+	for _, name := range others {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := NewPcapRecorder(RecordOptions{Dir: dir, FilePrefix: "netguard", MaxFileSize: 1, MaxFiles: 1})
+	data := newUDPPacket(t, "192.168.1.10", "8.8.8.8", 53000, 53, []byte("query"))
+	for i := 0; i < 3; i++ {
+		ci := gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(data), Length: len(data)}
+		if err := r.WritePacket(layers.LinkTypeEthernet, ci, data); err != nil {
+			t.Fatalf("录制数据包失败: %v", err)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatalf("关闭录制文件失败: %v", err)
+	}
+	files, err := r.Files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || filepath.Base(files[0]) == others[0] {
+		t.Fatalf("应只返回本录制器的 1 个文件，实际 %v", files)
+	}
+	for _, name := range others {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Fatalf("其他录制器的文件 %s 不应被删除: %v", name, err)
+		}
+	}
+}
+
+// 添加测试：链路类型不同的网卡交替写入同一个文件，不切换文件，回放时按各自的链路类型解码
+func TestPcapRecorderMixedLinkTypes(t *testing.T) {
+	dir := t.TempDir()
+	r := NewPcapRecorder(RecordOptions{Dir: dir, MaxFiles: 2})
+	ts := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		iface, linkType := "eth0", layers.LinkTypeEthernet
+		data := newUDPPacket(t, "192.168.1.10", "8.8.8.8", 53000, 53, []byte("query"))
+		if i%2 == 1 {
+			// tun 网卡没有以太网头
+			iface, linkType = "tun0", layers.LinkTypeRaw
+			data = newUDPPacket(t, "192.168.1.10", "1.1.1.1", 53001, 53, []byte("query"))[14:]
+		}
+		ci := gopacket.CaptureInfo{Timestamp: ts.Add(time.Duration(i) * time.Millisecond), CaptureLength: len(data), Length: len(data)}
+		if err := r.WriteInterfacePacket(iface, linkType, ci, data); err != nil {
+			t.Fatalf("录制数据包失败: %v", err)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatalf("关闭录制文件失败: %v", err)
+	}
+	files, err := r.Files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || r.seq != 1 {
+		t.Fatalf("不同链路类型的数据包应写入同一个文件，实际 %d 个文件，切换 %d 次", len(files), r.seq)
+	}
+
+	e := NewEngine(WithProcessResolver(newMockResolver()), WithLocalIPs(net.ParseIP("192.168.1.10")))
+	if err = e.RunWithFile(context.Background(), files[0]); err != nil {
+		t.Fatalf("回放录制文件失败: %v", err)
+	}
+	stats := e.GetTrafficStats()
+	if len(stats) != 2 {
+		t.Fatalf("两个网卡的数据包都应正确解码，应有 2 条记录，实际 %d", len(stats))
+	}
+	for _, tr := range stats {
+		if tr.PacketsSent != 5 {
+			t.Fatalf("每个网卡应有 5 个数据包，实际 %+v", tr)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package netguard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/iotames/netguard/log"
+)
+
+// 可通过 NewProcessResolver 选择的进程查找方式
+const (
+	ResolverGopsutil = "gopsutil" // 定期轮询系统连接表，支持所有平台。默认方式
+	ResolverProcfs   = "procfs"   // 解析 /proc/net，仅 Linux
+	ResolverNetlink  = "netlink"  // 通过 NETLINK_SOCK_DIAG 查询内核，仅 Linux
+)
+
+// 连接的进程未识别时，重新查找的最小间隔
+const processRetryInterval = 5 * time.Second
+
+// ErrProcessNotFound 没有找到本地套接字对应的进程
+var ErrProcessNotFound = errors.New("未找到套接字对应的进程")
+
+// ProcessInfo 套接字所属进程的信息
+type ProcessInfo struct {
+	PID  int32
+	Name string
+	Exe  string // 可执行文件路径。无权限时为空
+	User string // 进程所属用户名
+}
+
+// ProcessResolver 根据协议和本地地址查找拥有该套接字的进程。
+// 引擎在看到新连接时调用 Lookup，未找到的连接每隔一段时间重新查找。
+type ProcessResolver interface {
+	// Lookup 查找进程。protocol 为 "TCP" 或 "UDP"。找不到时返回 ErrProcessNotFound，
+	// 此时如果知道套接字所属的用户，可在返回的 ProcessInfo.User 中给出
+	Lookup(protocol string, localIP net.IP, localPort uint16) (ProcessInfo, error)
+}
+
+// backgroundResolver 需要在抓包期间运行后台任务的 ProcessResolver，如定期刷新连接表
+type backgroundResolver interface {
+	Run(ctx context.Context)
+}
+
+// rootedResolver 从指定 procfs 目录查找进程的 ProcessResolver。
+// 引擎从同一目录读取进程的命令行、父进程等详细信息
+type rootedResolver interface {
+	procfsRoot() string
+}
+
+// resolverProcfsRoot 进程查找器使用的 procfs 目录。为空表示读取本机进程
+func resolverProcfsRoot(r ProcessResolver) string {
+	if rr, ok := r.(rootedResolver); ok {
+		return rr.procfsRoot()
+	}
+	return ""
+}
+
+// NewProcessResolver 按名称创建进程查找器，名称见 ResolverGopsutil 等常量。
+// 当前平台不支持时返回错误。
+func NewProcessResolver(name string) (ProcessResolver, error) {
+	switch name {
+	case "", ResolverGopsutil:
+		return NewGopsutilResolver(true), nil
+	case ResolverProcfs, ResolverNetlink:
+		return newPlatformResolver(name)
+	}
+	return nil, fmt.Errorf("未知的进程查找方式: %s", name)
+}
+
+// resolvedProcess 连接所属的进程，以及父进程链和顶层应用
+type resolvedProcess struct {
+	ProcessMeta
+	chain []ProcessAncestor
+	app   ProcessAncestor
+}
+
+// processDetail 获取进程的父进程链和顶层应用
+func (e *Engine) processDetail(meta ProcessMeta) resolvedProcess {
+	p := resolvedProcess{ProcessMeta: meta}
+	if meta.PID <= 0 {
+		return p
+	}
+	p.chain = e.parentChain(meta.PPID)
+	p.app = e.topLevelApp(ProcessAncestor{PID: meta.PID, Name: meta.Name, Exe: meta.Exe}, p.chain)
+	return p
+}
+
+// resolveProcess 使用 ProcessResolver 查找连接所属的进程，并从缓存中补充进程的详细信息。
+// 找不到时 PID 为 0，User 为查找器给出的套接字所属用户
+func (e *Engine) resolveProcess(protocol string, localIP net.IP, localPort uint16) resolvedProcess {
+	info, err := e.processResolver.Lookup(protocol, localIP, localPort)
+	if err != nil {
+		if !errors.Is(err, ErrProcessNotFound) {
+			log.Debug("查找连接所属进程失败", "错误", err, "协议", protocol, "本地IP", localIP, "本地端口", localPort)
+			return resolvedProcess{}
+		}
+		return resolvedProcess{ProcessMeta: ProcessMeta{User: info.User}}
+	}
+	meta, ok := e.processMetas.get(info.PID)
+	if !ok {
+		meta = ProcessMeta{PID: info.PID}
+	}
+	// 查找器返回的信息优先
+	if info.Name != "" {
+		meta.Name = info.Name
+	}
+	if info.Exe != "" {
+		meta.Exe = info.Exe
+	}
+	if info.User != "" {
+		meta.User = info.User
+	}
+	return e.processDetail(meta)
+}
+
+// setProcess 设置连接所属的进程。调用方需持有写锁
+func (tr *TrafficRecord) setProcess(p resolvedProcess) {
+	tr.ProcessPID = p.PID
+	tr.ProcessName = p.Name
+	tr.ProcessExe = p.Exe
+	tr.ProcessCmdline = p.Cmdline
+	tr.ProcessUser = p.User
+	tr.ProcessPPID = p.PPID
+	tr.ProcessStartTime = p.CreateTime
+	tr.ParentChain = p.chain
+	tr.AppPID = p.app.PID
+	tr.AppName = p.app.Name
+	tr.ContainerID = p.ContainerID
+}
@@ -0,0 +1,214 @@
+package netguard
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/iotames/netguard/log"
+	gnet "github.com/shirou/gopsutil/v3/net"
+)
+
+// 两次实时查询系统连接表的最小间隔。查询一次即可得到所有连接，短时间内大量新连接无需重复查询
+const realTimeQueryInterval = time.Second
+
+// GopsutilResolver 通过 gopsutil 获取系统连接表查找进程，支持所有平台。
+// 抓包期间每5秒轮询一次连接表，查找时先查缓存和连接表，找不到再实时查询一次。
+type GopsutilResolver struct {
+	realTime      bool     // 连接表中找不到时是否实时查询
+	connectionMap sync.Map // 网络连接与进程的映射关系 key: "TCP IP:Port" string, value: int32 (PID)
+
+	cacheMutex    sync.RWMutex
+	cache         map[string]int32 // 进程查询缓存
+	lastRealTime  time.Time
+	realTimeMutex sync.Mutex
+}
+
+// NewGopsutilResolver 创建基于 gopsutil 的进程查找器。realTime 为连接表中找不到时是否实时查询系统连接表
+func NewGopsutilResolver(realTime bool) *GopsutilResolver {
+	return &GopsutilResolver{
+		realTime: realTime,
+		cache:    make(map[string]int32),
+	}
+}
+
+// Lookup 查找拥有本地套接字的进程
+//
+// 数据包到达
+//
+//	↓
+//
+// 新建连接时调用 Lookup
+//
+//	↓
+//
+// findPid：只查缓存和映射表，返回PID（可能为0）
+//
+//	↓
+//
+// 如果开启实时查询 && PID=0 → 触发实时查询
+func (r *GopsutilResolver) Lookup(protocol string, localIP net.IP, localPort uint16) (ProcessInfo, error) {
+	pid := r.findPid(protocol, localIP, localPort)
+	if pid == 0 && r.realTime {
+		pid = r.queryRealTime(protocol, localIP, localPort)
+	}
+	if pid == 0 {
+		return ProcessInfo{}, ErrProcessNotFound
+	}
+	// 进程名等信息由引擎从进程信息缓存中补充
+	return ProcessInfo{PID: pid}, nil
+}
+
+// Run 定期更新连接表和清理缓存，直到 ctx 被取消
+func (r *GopsutilResolver) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		r.updateConnectionMap(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		r.cleanupCache(ctx)
+	}()
+	wg.Wait()
+}
+
+// findPid 通过缓存和连接表查找进程PID。精确匹配不到时，匹配监听在 0.0.0.0 或 :: 上的套接字
+func (r *GopsutilResolver) findPid(protocol string, ip net.IP, port uint16) int32 {
+	unspecified := net.IPv6unspecified
+	if ip.To4() != nil {
+		unspecified = net.IPv4zero
+	}
+	for _, key := range []string{connKey(protocol, ip, port), connKey(protocol, unspecified, port)} {
+		// 1. 先查缓存（快速路径）
+		r.cacheMutex.RLock()
+		pid, exists := r.cache[key]
+		r.cacheMutex.RUnlock()
+		if exists {
+			return pid
+		}
+
+		// 2. 查连接映射表
+		if v, exists := r.connectionMap.Load(key); exists {
+			if pid, ok := v.(int32); ok && pid > 0 {
+				// 更新缓存
+				r.cacheMutex.Lock()
+				r.cache[key] = pid
+				r.cacheMutex.Unlock()
+				return pid
+			}
+		}
+	}
+	return 0
+}
+
+// queryRealTime 实时查询系统连接表，并用结果刷新连接映射表
+func (r *GopsutilResolver) queryRealTime(protocol string, ip net.IP, port uint16) int32 {
+	r.realTimeMutex.Lock()
+	if time.Since(r.lastRealTime) < realTimeQueryInterval {
+		r.realTimeMutex.Unlock()
+		return 0
+	}
+	r.lastRealTime = time.Now()
+	r.realTimeMutex.Unlock()
+
+	connMap, err := getConnectionMap()
+	if err != nil {
+		log.Warn("获取网络连接信息失败:", "错误", err)
+		return 0
+	}
+	for key, pid := range connMap {
+		r.connectionMap.Store(key, pid)
+	}
+	pid := r.findPid(protocol, ip, port)
+	if pid > 0 {
+		log.Debug("实时查询PID成功", "key", connKey(protocol, ip, port), "PID", pid)
+	}
+	return pid
+}
+
+// updateConnectionMap 定期更新网络连接与进程的映射关系
+func (r *GopsutilResolver) updateConnectionMap(ctx context.Context) {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		// 临时map用于批量更新
+		tempMap, err := getConnectionMap()
+		if err != nil {
+			log.Warn("获取网络连接信息失败:", "错误", err)
+			continue
+		}
+
+		// 原子性更新全局映射
+		for key, pid := range tempMap {
+			r.connectionMap.Store(key, pid)
+		}
+
+		// 清理过期的连接
+		r.connectionMap.Range(func(key, value interface{}) bool {
+			if _, exists := tempMap[key.(string)]; !exists {
+				r.connectionMap.Delete(key)
+			}
+			return true
+		})
+	}
+}
+
+// cleanupCache 定期清理进程查询缓存
+func (r *GopsutilResolver) cleanupCache(ctx context.Context) {
+	ticker := time.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		r.cacheMutex.Lock()
+		r.cache = make(map[string]int32) // 简单清空
+		r.cacheMutex.Unlock()
+		log.Debug("已清理进程查询缓存")
+	}
+}
+
+// getConnectionMap 获取系统连接表，返回 connKey 到 PID 的映射
+func getConnectionMap() (map[string]int32, error) {
+	connections, err := gnet.Connections("all")
+	if err != nil {
+		return nil, err
+	}
+	connMap := make(map[string]int32)
+	for _, conn := range connections {
+		if conn.Laddr.IP == "" || conn.Laddr.Port == 0 || conn.Pid <= 0 {
+			continue
+		}
+		var protocol string
+		switch conn.Type {
+		case 1: // SOCK_STREAM
+			protocol = "TCP"
+		case 2: // SOCK_DGRAM
+			protocol = "UDP"
+		default:
+			continue
+		}
+		// 标准化IP格式
+		ip := net.ParseIP(conn.Laddr.IP)
+		if ip != nil {
+			connMap[connKey(protocol, ip, uint16(conn.Laddr.Port))] = conn.Pid
+		}
+	}
+	return connMap, nil
+}
+
+// connKey 连接映射表的键，如 "TCP 10.0.0.5:443"
+func connKey(protocol string, ip net.IP, port uint16) string {
+	return fmt.Sprintf("%s %s:%d", protocol, ip.String(), port)
+}
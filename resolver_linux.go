@@ -0,0 +1,11 @@
+//go:build linux
+// +build linux
+
+package netguard
+
+func newPlatformResolver(name string) (ProcessResolver, error) {
+	if name == ResolverNetlink {
+		return NewNetlinkResolver(""), nil
+	}
+	return NewProcfsResolver(""), nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package netguard
+
+import "fmt"
+
+func newPlatformResolver(name string) (ProcessResolver, error) {
+	return nil, fmt.Errorf("当前平台不支持进程查找方式: %s", name)
+}
@@ -0,0 +1,187 @@
+package netguard
+
+import (
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockResolver 按 "协议 IP:端口" 返回预设进程的 ProcessResolver
+type mockResolver struct {
+	mu      sync.Mutex
+	procs   map[string]ProcessInfo
+	lookups int
+}
+
+func newMockResolver() *mockResolver {
+	return &mockResolver{procs: make(map[string]ProcessInfo)}
+}
+
+func (m *mockResolver) set(protocol string, ip net.IP, port uint16, info ProcessInfo) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.procs[connKey(protocol, ip, port)] = info
+}
+
+func (m *mockResolver) Lookup(protocol string, localIP net.IP, localPort uint16) (ProcessInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lookups++
+	if info, ok := m.procs[connKey(protocol, localIP, localPort)]; ok {
+		// PID 为0表示只知道套接字所属的用户
+		if info.PID == 0 {
+			return info, ErrProcessNotFound
+		}
+		return info, nil
+	}
+	return ProcessInfo{}, ErrProcessNotFound
+}
+
+// 添加测试：重试查找进程时，没有查到用户不覆盖之前查到的套接字所属用户
+func TestProcessRetryKeepsUser(t *testing.T) {
+	m := newMockResolver()
+	e := NewEngine(WithProcessResolver(m))
+	localIP := net.IPv4(10, 0, 0, 5)
+	m.set("TCP", localIP, 40000, ProcessInfo{User: "bob"})
+	now := time.Now()
+	send := func() {
+		e.updatePacketRecord(&packetInfo{localIP: localIP, localPort: 40000, remoteIP: net.IPv4(1, 1, 1, 1), remotePort: 443, protocol: "TCP", length: 100, timestamp: now})
+		now = now.Add(processRetryInterval)
+	}
+	user := func() (int32, string) {
+		tr := e.GetTrafficStats()[0]
+		return tr.ProcessPID, tr.ProcessUser
+	}
+	send()
+	// 套接字从连接表中消失，重试时既没有进程也没有用户
+	m.set("TCP", localIP, 40000, ProcessInfo{})
+	send()
+	if pid, u := user(); pid != 0 || u != "bob" {
+		t.Fatalf("重试未找到进程时应保留用户 bob，实际 %d/%q", pid, u)
+	}
+	// 找到进程但没有用户信息
+	m.set("TCP", localIP, 40000, ProcessInfo{PID: 2147483000, Name: "curl"})
+	send()
+	if pid, u := user(); pid != 2147483000 || u != "bob" {
+		t.Fatalf("找到进程时应保留之前的用户 bob，实际 %d/%q", pid, u)
+	}
+}
+
+// 添加测试：新建连接时查找进程，未找到的连接按间隔重试，已识别的连接不再查找
+func TestEngineProcessResolverRetry(t *testing.T) {
+	m := newMockResolver()
+	e := NewEngine(WithProcessResolver(m))
+	localIP := net.IPv4(10, 0, 0, 5)
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	send := func(sec int) {
+		e.updatePacketRecord(&packetInfo{
+			localIP:    localIP,
+			localPort:  40000,
+			remoteIP:   net.IPv4(1, 1, 1, 1),
+			remotePort: 443,
+			protocol:   "TCP",
+			length:     100,
+			timestamp:  base.Add(time.Duration(sec) * time.Second),
+		})
+	}
+	send(0)
+	if m.lookups != 1 || e.GetTrafficStats()[0].ProcessPID != 0 {
+		t.Fatalf("新建连接应查找 1 次且未识别，实际查找 %d 次", m.lookups)
+	}
+	send(1)
+	if m.lookups != 1 {
+		t.Fatalf("重试间隔内不应再次查找，实际 %d 次", m.lookups)
+	}
+	m.set("TCP", localIP, 40000, ProcessInfo{PID: 42, Name: "curl", Exe: "/usr/bin/curl", User: "alice"})
+	send(6)
+	tr := e.GetTrafficStats()[0]
+	if m.lookups != 2 || tr.ProcessPID != 42 || tr.ProcessExe != "/usr/bin/curl" || tr.ProcessUser != "alice" {
+		t.Fatalf("超过重试间隔后应识别到进程，实际查找 %d 次 %+v", m.lookups, tr)
+	}
+	send(20)
+	if m.lookups != 2 {
+		t.Fatalf("已识别的连接不应再查找，实际 %d 次", m.lookups)
+	}
+}
+
+// 添加测试：按名称创建进程查找器
+func TestNewProcessResolver(t *testing.T) {
+	for _, name := range []string{"", ResolverGopsutil} {
+		r, err := NewProcessResolver(name)
+		if err != nil {
+			t.Fatalf("创建 %q 失败: %v", name, err)
+		}
+		if _, ok := r.(*GopsutilResolver); !ok {
+			t.Fatalf("%q 应创建 GopsutilResolver，实际 %T", name, r)
+		}
+	}
+	if _, err := NewProcessResolver("unknown"); err == nil {
+		t.Fatal("未知的查找方式应返回错误")
+	}
+}
+
+// 添加测试：GopsutilResolver 通过实时查询找到本进程监听的端口
+func TestGopsutilResolverLookup(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("无法监听端口: %v", err)
+	}
+	defer ln.Close()
+	port := uint16(ln.Addr().(*net.TCPAddr).Port)
+
+	r := NewGopsutilResolver(true)
+	info, err := r.Lookup("TCP", net.IPv4(127, 0, 0, 1), port)
+	if err != nil {
+		t.Skipf("当前环境无法获取系统连接表: %v", err)
+	}
+	if info.PID != int32(os.Getpid()) {
+		t.Fatalf("监听端口应属于当前进程 %d，实际 %+v", os.Getpid(), info)
+	}
+}
+
+// 添加测试：按用户汇总流量，找不到进程时使用套接字所属的用户
+func TestGetUserStats(t *testing.T) {
+	m := newMockResolver()
+	e := NewEngine(WithProcessResolver(m))
+	localIP := net.IPv4(10, 0, 0, 5)
+	m.set("TCP", localIP, 40000, ProcessInfo{PID: 42, Name: "curl", User: "alice"})
+	m.set("TCP", localIP, 40001, ProcessInfo{PID: 43, Name: "wget", User: "alice"})
+	m.set("TCP", localIP, 40002, ProcessInfo{User: "bob"})
+	now := time.Now()
+	send := func(port uint16, remote net.IP, length uint64) {
+		e.updatePacketRecord(&packetInfo{
+			localIP:    localIP,
+			localPort:  port,
+			remoteIP:   remote,
+			remotePort: 443,
+			protocol:   "TCP",
+			length:     length,
+			timestamp:  now,
+		})
+	}
+	send(40000, net.IPv4(1, 1, 1, 1), 100)
+	send(40001, net.IPv4(1, 1, 1, 1), 200)
+	send(40002, net.IPv4(8, 8, 8, 8), 1000)
+	send(40003, net.IPv4(8, 8, 4, 4), 50)
+
+	stats := e.GetUserStats()
+	if len(stats) != 3 {
+		t.Fatalf("应汇总为 3 个用户，实际 %d", len(stats))
+	}
+	if s := stats[0]; s.User != "bob" || s.ProcessCount != 1 || s.FlowCount != 1 {
+		t.Fatalf("流量最大的应为 bob，实际 %+v", s)
+	}
+	if s := stats[1]; s.User != "alice" || s.ProcessCount != 2 || s.FlowCount != 2 || s.RemoteHostCount != 1 {
+		t.Fatalf("alice 应有 2 个进程 2 条连接 1 个对端，实际 %+v", s)
+	}
+	if s := stats[2]; s.User != "" || s.FlowCount != 1 {
+		t.Fatalf("未识别用户的流量应汇总为一条，实际 %+v", s)
+	}
+	for _, p := range e.GetProcessStats() {
+		if p.ProcessPID == 42 && p.User != "alice" {
+			t.Fatalf("进程汇总应带上用户，实际 %+v", p)
+		}
+	}
+}
@@ -3,10 +3,12 @@
 CREATE TABLE IF NOT EXISTS ng_hook_logs (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
     remote_ip VARCHAR(45) NOT NULL,
+    remote_host VARCHAR(255),
     remote_port INTEGER,
     protocol VARCHAR(10),
     process_name VARCHAR(255),
     process_pid INTEGER,
+    container_id VARCHAR(64),
     bytes_current_len BIGINT,
     inbound BOOLEAN,
     ip_country VARCHAR(100),
@@ -17,4 +19,27 @@ CREATE TABLE IF NOT EXISTS ng_hook_logs (
 -- 创建索引以优化查询性能
 CREATE INDEX IF NOT EXISTS idx_logs_remote_ip ON ng_hook_logs(remote_ip);
 CREATE INDEX IF NOT EXISTS idx_logs_timestamp ON ng_hook_logs(created_at);
-CREATE INDEX IF NOT EXISTS idx_logs_process ON ng_hook_logs(process_name);
\ No newline at end of file
+CREATE INDEX IF NOT EXISTS idx_logs_process ON ng_hook_logs(process_name);
+
+-- 从明文HTTP流量中解析出的请求
+CREATE TABLE IF NOT EXISTS ng_http_requests (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    client_ip VARCHAR(45) NOT NULL,
+    client_port INTEGER,
+    server_ip VARCHAR(45) NOT NULL,
+    server_port INTEGER,
+    method VARCHAR(16),
+    host VARCHAR(255),
+    request_url VARCHAR(1000) NOT NULL,
+    user_agent VARCHAR(500),
+    http_referer VARCHAR(255),
+    x_forwarded_for VARCHAR(255),
+    request_headers TEXT,
+    process_name VARCHAR(255),
+    process_pid INTEGER,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_requests_client_ip ON ng_http_requests(client_ip);
+CREATE INDEX IF NOT EXISTS idx_requests_created_at ON ng_http_requests(created_at);
+CREATE INDEX IF NOT EXISTS idx_requests_host ON ng_http_requests(host);
\ No newline at end of file
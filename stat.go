@@ -1,23 +1,90 @@
 package netguard
 
-// GetTrafficStats 获取流量统计信息（用于外部访问）
+import (
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// GetTrafficStats 获取默认引擎的流量统计信息（用于外部访问）
 func GetTrafficStats() []*TrafficRecord {
+	return DefaultEngine().GetTrafficStats()
+}
+
+// GetTrafficStats 获取流量统计信息（用于外部访问）。
+// 返回的速率为当前时刻的速率，长时间没有数据包的连接速率会衰减到0。
+// 离线回放的数据包时间与当前时间无关，速率取各连接最后一个数据包时刻的值。
+// 不包含转发流量，转发流量见 GetTransitStats。
+func (e *Engine) GetTrafficStats() []*TrafficRecord {
+	return e.copyRecords(&e.trafficMap)
+}
+
+// GetTransitStats 获取默认引擎的转发流量统计
+func GetTransitStats() []*TrafficRecord {
+	return DefaultEngine().GetTransitStats()
+}
+
+// GetTransitStats 获取转发流量统计，即两端都不是本机IP或本地网络的流量，如网关转发、混杂模式下其他主机之间的通信。
+// 两个方向的数据包合并为一条记录，地址较小的一端作为 LocalIP，BytesSent 为从该端发出的字节数。转发流量不查找进程。
+func (e *Engine) GetTransitStats() []*TrafficRecord {
+	return e.copyRecords(&e.transitMap)
+}
+
+// copyRecords 复制流量表中的所有记录
+func (e *Engine) copyRecords(flows *sync.Map) []*TrafficRecord {
 	var stats []*TrafficRecord
-	trafficMap.Range(func(key, value interface{}) bool {
+	offline := e.isOffline()
+	now := time.Now()
+	flows.Range(func(key, value interface{}) bool {
 		if record, ok := value.(*TrafficRecord); ok {
 			// 创建副本避免并发问题
 			record.RLock()
 			stat := &TrafficRecord{
-				LocalIP:       record.LocalIP,
-				LocalPort:     record.LocalPort,
-				RemoteIP:      record.RemoteIP,
-				RemotePort:    record.RemotePort,
-				Protocol:      record.Protocol,
-				ProcessName:   record.ProcessName,
-				ProcessPID:    record.ProcessPID,
-				BytesSent:     record.BytesSent,
-				BytesReceived: record.BytesReceived,
-				LastUpdate:    record.LastUpdate,
+				LocalIP:     record.LocalIP,
+				LocalPort:   record.LocalPort,
+				RemoteIP:    record.RemoteIP,
+				RemotePort:  record.RemotePort,
+				Protocol:    record.Protocol,
+				ProcessName: record.ProcessName,
+				ProcessPID:  record.ProcessPID,
+				ProcessExe:  record.ProcessExe,
+				ProcessUser: record.ProcessUser,
+
+				ProcessCmdline:   record.ProcessCmdline,
+				ProcessPPID:      record.ProcessPPID,
+				ProcessStartTime: record.ProcessStartTime,
+				ParentChain:      record.ParentChain,
+				AppPID:           record.AppPID,
+				AppName:          record.AppName,
+				ContainerID:      record.ContainerID,
+				Interface:        record.Interface,
+				LocalPeer:        record.LocalPeer,
+				Direction:        record.Direction,
+				RemoteClass:      record.RemoteClass,
+				RemoteHost:       record.RemoteHost,
+				BytesSent:        record.BytesSent,
+				BytesReceived:    record.BytesReceived,
+				LastUpdate:       record.LastUpdate,
+
+				PacketsSent:     record.PacketsSent,
+				PacketsReceived: record.PacketsReceived,
+				FirstSeen:       record.FirstSeen,
+
+				TCPState:        record.TCPState,
+				ConnStartTime:   record.ConnStartTime,
+				ConnEndTime:     record.ConnEndTime,
+				Retransmissions: record.Retransmissions,
+				OutOfOrder:      record.OutOfOrder,
+				TLS:             record.TLS,
+				HTTP:            record.HTTP,
+				HTTPRequests:    record.HTTPRequests,
+			}
+			if offline {
+				stat.Rate = record.rates.at(record.LastUpdate)
+			} else {
+				stat.Rate = record.rates.at(now)
 			}
 			record.RUnlock()
 			stats = append(stats, stat)
@@ -27,6 +94,279 @@ func GetTrafficStats() []*TrafficRecord {
 	return stats
 }
 
+// SocketStat 按本地套接字（协议+本地IP+本地端口）汇总的流量统计
+type SocketStat struct {
+	LocalIP         net.IP
+	LocalPort       uint16
+	Protocol        string
+	ProcessName     string
+	ProcessPID      int32
+	BytesSent       uint64
+	BytesReceived   uint64
+	PacketsSent     uint64
+	PacketsReceived uint64
+	Rate            TrafficRate // 所有连接的速率之和
+	FlowCount       int         // 该套接字通信过的对端数（五元组连接数）
+	LastUpdate      time.Time   // 所有连接中最近的更新时间
+}
+
+// GetSocketStats 获取默认引擎按本地套接字汇总的流量统计
+func GetSocketStats() []*SocketStat {
+	return DefaultEngine().GetSocketStats()
+}
+
+// GetSocketStats 按本地套接字汇总流量统计。
+// 流量表以五元组为键，同一个UDP套接字与多个对端的通信是多条记录，这里把它们合并为一条。
+func (e *Engine) GetSocketStats() []*SocketStat {
+	socketMap := make(map[string]*SocketStat)
+	var stats []*SocketStat
+	for _, tr := range e.GetTrafficStats() {
+		key := tr.Protocol + " " + net.JoinHostPort(tr.LocalIP.String(), strconv.Itoa(int(tr.LocalPort)))
+		stat, ok := socketMap[key]
+		if !ok {
+			stat = &SocketStat{
+				LocalIP:   tr.LocalIP,
+				LocalPort: tr.LocalPort,
+				Protocol:  tr.Protocol,
+			}
+			socketMap[key] = stat
+			stats = append(stats, stat)
+		}
+		stat.BytesSent += tr.BytesSent
+		stat.BytesReceived += tr.BytesReceived
+		stat.PacketsSent += tr.PacketsSent
+		stat.PacketsReceived += tr.PacketsReceived
+		stat.Rate.add(tr.Rate)
+		stat.FlowCount++
+		if tr.LastUpdate.After(stat.LastUpdate) {
+			stat.LastUpdate = tr.LastUpdate
+		}
+		// 使用最近一次识别到的进程信息
+		if tr.ProcessPID > 0 && (stat.ProcessPID == 0 || !tr.LastUpdate.Before(stat.LastUpdate)) {
+			stat.ProcessPID = tr.ProcessPID
+			stat.ProcessName = tr.ProcessName
+		}
+	}
+	return stats
+}
+
+// InterfaceStat 按网卡汇总的流量统计
+type InterfaceStat struct {
+	Interface       string // 网卡名。离线回放的流量为空
+	BytesSent       uint64
+	BytesReceived   uint64
+	PacketsSent     uint64
+	PacketsReceived uint64
+	Rate            TrafficRate
+	FlowCount       int
+	LastUpdate      time.Time
+}
+
+// GetInterfaceStats 获取默认引擎按网卡汇总的流量统计
+func GetInterfaceStats() []*InterfaceStat {
+	return DefaultEngine().GetInterfaceStats()
+}
+
+// GetInterfaceStats 按网卡汇总流量统计，按网卡名排序。同时监控多个网卡时用于比较各网卡的流量
+func (e *Engine) GetInterfaceStats() []*InterfaceStat {
+	ifaceMap := make(map[string]*InterfaceStat)
+	var stats []*InterfaceStat
+	for _, tr := range e.GetTrafficStats() {
+		stat, ok := ifaceMap[tr.Interface]
+		if !ok {
+			stat = &InterfaceStat{Interface: tr.Interface}
+			ifaceMap[tr.Interface] = stat
+			stats = append(stats, stat)
+		}
+		stat.BytesSent += tr.BytesSent
+		stat.BytesReceived += tr.BytesReceived
+		stat.PacketsSent += tr.PacketsSent
+		stat.PacketsReceived += tr.PacketsReceived
+		stat.Rate.add(tr.Rate)
+		stat.FlowCount++
+		if tr.LastUpdate.After(stat.LastUpdate) {
+			stat.LastUpdate = tr.LastUpdate
+		}
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Interface < stats[j].Interface
+	})
+	return stats
+}
+
+// ProcessGroupBy 按进程汇总流量时的分组方式
+type ProcessGroupBy int
+
+const (
+	GroupByProcess   ProcessGroupBy = iota // 按进程本身分组
+	GroupByApp                             // 按顶层应用分组，如浏览器的所有子进程合并为一条
+	GroupByContainer                       // 按容器分组，不在容器中的进程合并为 ContainerID 为空的一条
+)
+
+// ProcessStat 按进程汇总的流量统计
+type ProcessStat struct {
+	ProcessPID      int32 // 0 表示未识别到进程的流量。按顶层应用分组时为顶层应用的PID
+	ProcessName     string
+	ProcessCount    int    // 汇总的不同进程数，按进程分组时为1
+	User            string // 进程所属用户。按顶层应用或容器分组时为最近一条连接的用户
+	ContainerID     string // 进程所在容器的ID。按容器分组时 ProcessPID 为0
+	Exe             string // 可执行文件路径，取自最近一条连接。无权限时为空
+	Cmdline         string // 命令行，取自最近一条连接。无权限时为空
+	BytesSent       uint64
+	BytesReceived   uint64
+	PacketsSent     uint64
+	PacketsReceived uint64
+	Rate            TrafficRate // 所有连接的速率之和，BytesPerSec60s 即最近一分钟的平均速率
+	FlowCount       int         // 五元组连接数
+	RemoteHostCount int         // 通信过的不同对端IP数
+	LastUpdate      time.Time
+}
+
+// GetProcessStats 获取默认引擎按进程汇总的流量统计
+func GetProcessStats() []*ProcessStat {
+	return DefaultEngine().GetProcessStats()
+}
+
+// GetProcessStatsBy 获取默认引擎按指定方式汇总的进程流量统计
+func GetProcessStatsBy(groupBy ProcessGroupBy) []*ProcessStat {
+	return DefaultEngine().GetProcessStatsBy(groupBy)
+}
+
+// GetProcessStats 按进程（PID+进程名）汇总流量统计，按收发总字节数从大到小排序。
+// 未识别到进程的流量汇总为 PID 为 0 的一条。
+func (e *Engine) GetProcessStats() []*ProcessStat {
+	return e.GetProcessStatsBy(GroupByProcess)
+}
+
+// GetProcessStatsBy 按进程、顶层应用或容器汇总流量统计，按收发总字节数从大到小排序。
+// 按顶层应用分组时，没有父进程信息的连接按进程本身分组。
+func (e *Engine) GetProcessStatsBy(groupBy ProcessGroupBy) []*ProcessStat {
+	type procAgg struct {
+		stat    *ProcessStat
+		remotes map[string]struct{}
+		pids    map[int32]struct{}
+	}
+	procMap := make(map[string]*procAgg)
+	var stats []*ProcessStat
+	for _, tr := range e.GetTrafficStats() {
+		pid, name := tr.ProcessPID, tr.ProcessName
+		key := strconv.Itoa(int(pid)) + " " + name
+		switch {
+		case groupBy == GroupByApp && tr.AppPID > 0:
+			pid, name = tr.AppPID, tr.AppName
+			key = strconv.Itoa(int(pid)) + " " + name
+		case groupBy == GroupByContainer:
+			pid, name = 0, ""
+			key = tr.ContainerID
+		}
+		agg, ok := procMap[key]
+		if !ok {
+			agg = &procAgg{
+				stat: &ProcessStat{
+					ProcessPID:  pid,
+					ProcessName: name,
+					ContainerID: tr.ContainerID,
+				},
+				remotes: make(map[string]struct{}),
+				pids:    make(map[int32]struct{}),
+			}
+			procMap[key] = agg
+			stats = append(stats, agg.stat)
+		}
+		stat := agg.stat
+		stat.BytesSent += tr.BytesSent
+		stat.BytesReceived += tr.BytesReceived
+		stat.PacketsSent += tr.PacketsSent
+		stat.PacketsReceived += tr.PacketsReceived
+		stat.Rate.add(tr.Rate)
+		stat.FlowCount++
+		agg.remotes[tr.RemoteIP.String()] = struct{}{}
+		agg.pids[tr.ProcessPID] = struct{}{}
+		if tr.ProcessUser != "" && (stat.User == "" || !tr.LastUpdate.Before(stat.LastUpdate)) {
+			stat.User = tr.ProcessUser
+		}
+		// 按顶层应用分组时，只取应用进程自身的连接
+		if tr.ProcessPID == pid && (tr.ProcessExe != "" || tr.ProcessCmdline != "") &&
+			(stat.Exe == "" && stat.Cmdline == "" || !tr.LastUpdate.Before(stat.LastUpdate)) {
+			stat.Exe, stat.Cmdline = tr.ProcessExe, tr.ProcessCmdline
+		}
+		if tr.LastUpdate.After(stat.LastUpdate) {
+			stat.LastUpdate = tr.LastUpdate
+		}
+	}
+	for _, agg := range procMap {
+		agg.stat.RemoteHostCount = len(agg.remotes)
+		agg.stat.ProcessCount = len(agg.pids)
+	}
+	sort.SliceStable(stats, func(i, j int) bool {
+		return stats[i].BytesSent+stats[i].BytesReceived > stats[j].BytesSent+stats[j].BytesReceived
+	})
+	return stats
+}
+
+// UserStat 按进程所属用户汇总的流量统计
+type UserStat struct {
+	User            string // 用户名，无法解析时为UID。空表示未识别到用户的流量
+	ProcessCount    int    // 不同进程数，未识别到进程的连接计为 PID 0
+	BytesSent       uint64
+	BytesReceived   uint64
+	PacketsSent     uint64
+	PacketsReceived uint64
+	Rate            TrafficRate
+	FlowCount       int
+	RemoteHostCount int
+	LastUpdate      time.Time
+}
+
+// GetUserStats 获取默认引擎按用户汇总的流量统计
+func GetUserStats() []*UserStat {
+	return DefaultEngine().GetUserStats()
+}
+
+// GetUserStats 按连接所属用户汇总流量统计，按收发总字节数从大到小排序。
+// 在多用户的机器上用于找出产生流量的账户。未识别到用户的流量汇总为 User 为空的一条。
+func (e *Engine) GetUserStats() []*UserStat {
+	type userAgg struct {
+		stat    *UserStat
+		remotes map[string]struct{}
+		pids    map[int32]struct{}
+	}
+	userMap := make(map[string]*userAgg)
+	var stats []*UserStat
+	for _, tr := range e.GetTrafficStats() {
+		agg, ok := userMap[tr.ProcessUser]
+		if !ok {
+			agg = &userAgg{
+				stat:    &UserStat{User: tr.ProcessUser},
+				remotes: make(map[string]struct{}),
+				pids:    make(map[int32]struct{}),
+			}
+			userMap[tr.ProcessUser] = agg
+			stats = append(stats, agg.stat)
+		}
+		stat := agg.stat
+		stat.BytesSent += tr.BytesSent
+		stat.BytesReceived += tr.BytesReceived
+		stat.PacketsSent += tr.PacketsSent
+		stat.PacketsReceived += tr.PacketsReceived
+		stat.Rate.add(tr.Rate)
+		stat.FlowCount++
+		agg.remotes[tr.RemoteIP.String()] = struct{}{}
+		agg.pids[tr.ProcessPID] = struct{}{}
+		if tr.LastUpdate.After(stat.LastUpdate) {
+			stat.LastUpdate = tr.LastUpdate
+		}
+	}
+	for _, agg := range userMap {
+		agg.stat.RemoteHostCount = len(agg.remotes)
+		agg.stat.ProcessCount = len(agg.pids)
+	}
+	sort.SliceStable(stats, func(i, j int) bool {
+		return stats[i].BytesSent+stats[i].BytesReceived > stats[j].BytesSent+stats[j].BytesReceived
+	})
+	return stats
+}
+
 // type Status struct{}
 // func (s Status) GetProcessMapLen() int {
 // 	return len(connectionMap)
@@ -0,0 +1,146 @@
+package netguard
+
+import (
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+// TCPState 从抓到的数据包推断出的TCP连接状态
+type TCPState uint8
+
+const (
+	TCPStateNone        TCPState = iota // 非TCP连接，或尚未看到TCP数据包
+	TCPStateSynSent                     // 看到SYN
+	TCPStateSynReceived                 // 看到SYN+ACK
+	TCPStateEstablished                 // 握手完成，或抓包开始时连接已建立
+	TCPStateFinWait                     // 一方发送了FIN
+	TCPStateClosed                      // 双方都发送了FIN
+	TCPStateReset                       // 收到或发送了RST
+)
+
+func (s TCPState) String() string {
+	switch s {
+	case TCPStateSynSent:
+		return "SYN_SENT"
+	case TCPStateSynReceived:
+		return "SYN_RECEIVED"
+	case TCPStateEstablished:
+		return "ESTABLISHED"
+	case TCPStateFinWait:
+		return "FIN_WAIT"
+	case TCPStateClosed:
+		return "CLOSED"
+	case TCPStateReset:
+		return "RESET"
+	}
+	return "NONE"
+}
+
+// IsClosed 连接是否已结束（双方FIN或RST）
+func (s TCPState) IsClosed() bool {
+	return s == TCPStateClosed || s == TCPStateReset
+}
+
+// 数据包方向，用作 tcpTracker 中按方向记录状态的下标
+const (
+	dirOutbound = 0
+	dirInbound  = 1
+)
+
+// tcpTracker 按方向记录TCP序列号，用于判断重传和乱序
+type tcpTracker struct {
+	nextSeq [2]uint32 // 每个方向期望的下一个序列号
+	seqInit [2]bool   // 是否已记录该方向的序列号
+	finSeen [2]bool   // 该方向是否发送过FIN
+	payload bool      // 是否已看到带载荷的数据包，只在第一段载荷中查找TLS ClientHello
+}
+
+// seqLess 按序列号回绕规则比较 a < b
+func seqLess(a, b uint32) bool {
+	return int32(a-b) < 0
+}
+
+// updateTCP 根据TCP数据包更新连接状态、起止时间和重传/乱序计数。调用方需持有写锁。
+func (tr *TrafficRecord) updateTCP(tcp *layers.TCP, inbound bool, now time.Time) {
+	dir := dirOutbound
+	if inbound {
+		dir = dirInbound
+	}
+
+	// 连接结束后又看到新的SYN，说明端口被复用，开始一个新的连接
+	if tcp.SYN && !tcp.ACK && tr.TCPState.IsClosed() {
+		tr.TCPState = TCPStateNone
+		tr.ConnEndTime = time.Time{}
+		tr.TLS = TLSInfo{}
+		tr.HTTP, tr.HTTPRequests = nil, 0
+		tr.tcp = tcpTracker{}
+	}
+
+	switch {
+	case tcp.RST:
+		tr.TCPState = TCPStateReset
+	case tcp.SYN && !tcp.ACK:
+		if tr.TCPState == TCPStateNone {
+			tr.TCPState = TCPStateSynSent
+			tr.ConnStartTime = now
+		}
+	case tcp.SYN && tcp.ACK:
+		if tr.TCPState == TCPStateNone || tr.TCPState == TCPStateSynSent {
+			tr.TCPState = TCPStateSynReceived
+		}
+	case tcp.FIN:
+		tr.tcp.finSeen[dir] = true
+		if tr.tcp.finSeen[dirOutbound] && tr.tcp.finSeen[dirInbound] {
+			tr.TCPState = TCPStateClosed
+		} else if !tr.TCPState.IsClosed() {
+			tr.TCPState = TCPStateFinWait
+		}
+	default:
+		// 握手后的ACK或数据包。抓包开始前已建立的连接，以第一个数据包为开始
+		if tr.TCPState == TCPStateNone || tr.TCPState == TCPStateSynSent || tr.TCPState == TCPStateSynReceived {
+			tr.TCPState = TCPStateEstablished
+		}
+	}
+	if tr.ConnStartTime.IsZero() {
+		tr.ConnStartTime = now
+	}
+	if tr.TCPState.IsClosed() && tr.ConnEndTime.IsZero() {
+		tr.ConnEndTime = now
+	}
+
+	// SYN 和 FIN 各占用一个序列号
+	segLen := uint32(len(tcp.Payload))
+	if tcp.SYN {
+		segLen++
+	}
+	if tcp.FIN {
+		segLen++
+	}
+	if segLen == 0 {
+		// 纯ACK不占用序列号，无法判断重传
+		return
+	}
+	segEnd := tcp.Seq + segLen
+	if !tr.tcp.seqInit[dir] {
+		tr.tcp.seqInit[dir] = true
+		tr.tcp.nextSeq[dir] = segEnd
+		return
+	}
+	next := tr.tcp.nextSeq[dir]
+	switch {
+	case !seqLess(next, segEnd):
+		// 数据已全部出现过
+		tr.Retransmissions++
+	case seqLess(next, tcp.Seq):
+		// 中间有数据缺失，后面的数据先到
+		tr.OutOfOrder++
+		tr.tcp.nextSeq[dir] = segEnd
+	case seqLess(tcp.Seq, next):
+		// 部分数据与已出现的数据重叠
+		tr.Retransmissions++
+		tr.tcp.nextSeq[dir] = segEnd
+	default:
+		tr.tcp.nextSeq[dir] = segEnd
+	}
+}
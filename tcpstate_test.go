@@ -0,0 +1,85 @@
+package netguard
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+// 添加测试：回放一个完整的TCP连接，校验状态、起止时间、重传和乱序计数
+func TestTCPStateTracking(t *testing.T) {
+	const local, remote = "192.168.1.10", "93.184.216.34"
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	var packets []testPacket
+	add := func(out bool, tcp *layers.TCP, payload string) {
+		var data []byte
+		if out {
+			data = newTCPPacket(t, local, remote, 50000, 443, tcp, []byte(payload))
+		} else {
+			data = newTCPPacket(t, remote, local, 443, 50000, tcp, []byte(payload))
+		}
+		packets = append(packets, testPacket{ts: base.Add(time.Duration(len(packets)) * time.Second), data: data})
+	}
+	add(true, &layers.TCP{SYN: true, Seq: 1000}, "")
+	add(false, &layers.TCP{SYN: true, ACK: true, Seq: 5000, Ack: 1001}, "")
+	add(true, &layers.TCP{ACK: true, Seq: 1001, Ack: 5001}, "")
+	add(true, &layers.TCP{ACK: true, PSH: true, Seq: 1001, Ack: 5001}, "hello")
+	// 重传相同的数据
+	add(true, &layers.TCP{ACK: true, PSH: true, Seq: 1001, Ack: 5001}, "hello")
+	// 对端的数据跳过了 5001-5005，后面的数据先到
+	add(false, &layers.TCP{ACK: true, Seq: 5006, Ack: 1006}, "world")
+	add(true, &layers.TCP{FIN: true, ACK: true, Seq: 1006, Ack: 5011}, "")
+	add(false, &layers.TCP{FIN: true, ACK: true, Seq: 5011, Ack: 1007}, "")
+	add(true, &layers.TCP{ACK: true, Seq: 1007, Ack: 5012}, "")
+
+	e := replayTestPcap(t, packets, false)
+	stats := e.GetTrafficStats()
+	if len(stats) != 1 {
+		t.Fatalf("应有 1 条流量记录，实际 %d", len(stats))
+	}
+	tr := stats[0]
+	if tr.TCPState != TCPStateClosed {
+		t.Fatalf("连接状态应为 %s，实际 %s", TCPStateClosed, tr.TCPState)
+	}
+	if !tr.ConnStartTime.Equal(base) {
+		t.Fatalf("连接开始时间应为SYN的时间 %v，实际 %v", base, tr.ConnStartTime)
+	}
+	if want := base.Add(7 * time.Second); !tr.ConnEndTime.Equal(want) {
+		t.Fatalf("连接结束时间应为第二个FIN的时间 %v，实际 %v", want, tr.ConnEndTime)
+	}
+	if tr.Retransmissions != 1 {
+		t.Fatalf("重传数应为 1，实际 %d", tr.Retransmissions)
+	}
+	if tr.OutOfOrder != 1 {
+		t.Fatalf("乱序数应为 1，实际 %d", tr.OutOfOrder)
+	}
+
+	// 已关闭的连接在 closedFlowTimeout 之后被清理，不必等待空闲超时
+	e.evictTrafficRecords(tr.ConnEndTime.Add(e.closedFlowTimeout/2), time.Hour)
+	if len(e.GetTrafficStats()) != 1 {
+		t.Fatal("关闭不久的连接不应被清理")
+	}
+	e.evictTrafficRecords(tr.ConnEndTime.Add(e.closedFlowTimeout+time.Second), time.Hour)
+	if len(e.GetTrafficStats()) != 0 {
+		t.Fatal("关闭超过 closedFlowTimeout 的连接应被清理")
+	}
+}
+
+// 添加测试：RST 立即结束连接；端口复用的新SYN开始一个新连接
+func TestTCPStateResetAndReuse(t *testing.T) {
+	tr := &TrafficRecord{}
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tr.updateTCP(&layers.TCP{ACK: true, Seq: 1}, false, now)
+	if tr.TCPState != TCPStateEstablished {
+		t.Fatalf("抓包开始前已建立的连接应为 %s，实际 %s", TCPStateEstablished, tr.TCPState)
+	}
+	tr.updateTCP(&layers.TCP{RST: true, Seq: 1}, true, now.Add(time.Second))
+	if tr.TCPState != TCPStateReset || !tr.ConnEndTime.Equal(now.Add(time.Second)) {
+		t.Fatalf("RST 后应为 %s 并记录结束时间，实际 %s %v", TCPStateReset, tr.TCPState, tr.ConnEndTime)
+	}
+	tr.updateTCP(&layers.TCP{SYN: true, Seq: 100}, false, now.Add(2*time.Second))
+	if tr.TCPState != TCPStateSynSent || !tr.ConnEndTime.IsZero() || !tr.ConnStartTime.Equal(now.Add(2*time.Second)) {
+		t.Fatalf("端口复用的新SYN应开始新连接，实际 %s %v %v", tr.TCPState, tr.ConnStartTime, tr.ConnEndTime)
+	}
+}
@@ -1,91 +1,65 @@
 package netguard
 
 import (
-	"fmt"
-	"net"
+	"context"
+	"sync"
 	"time"
-
-	"github.com/iotames/netguard/log"
-	gnet "github.com/shirou/gopsutil/v3/net"
 )
 
-// cleanTrafficMap 定期清理长时间未更新的trafficMap记录
-func cleanTrafficMap(d time.Duration) {
+// cleanTrafficMap 定期清理长时间未更新的trafficMap记录，以及已关闭的TCP连接
+func (e *Engine) cleanTrafficMap(ctx context.Context) {
+	d := e.cleanInterval
 	if d <= 0 {
 		d = 10 * time.Minute
 	}
-	ticker := time.NewTicker(d)
-	for range ticker.C {
-		trafficMap.Range(func(key, value interface{}) bool {
-			if record, ok := value.(*TrafficRecord); ok {
-				record.RLock()
-				if time.Since(record.LastUpdate) > d {
-					trafficMap.Delete(key)
-				}
-				record.RUnlock()
-			}
-			return true
-		})
+	// 已关闭的连接需要及时清理，检查周期不能超过 closedFlowTimeout
+	tick := d
+	if e.closedFlowTimeout > 0 && e.closedFlowTimeout < tick {
+		tick = e.closedFlowTimeout
 	}
-}
-
-// periodicallyUpdateLocalIPs 定期更新本地IP列表
-func periodicallyUpdateLocalIPs() {
-	ticker := time.NewTicker(30 * time.Second)
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
 	for {
-		<-ticker.C
-		updateLocalIPs()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		e.evictTrafficRecords(time.Now(), d)
 	}
 }
 
-// updateProcessConnectionMap 定期更新网络连接与进程的映射关系
-func updateProcessConnectionMap() {
-	ticker := time.NewTicker(5 * time.Second)
-	for {
-		<-ticker.C
-		connections, err := gnet.Connections("all")
-		if err != nil {
-			log.Warn("获取网络连接信息失败:", "错误", err)
-			continue
-		}
-
-		// 临时map用于批量更新
-		tempMap := make(map[string]int32)
-
-		for _, conn := range connections {
-			if conn.Laddr.IP != "" && conn.Laddr.Port != 0 {
-				// 标准化IP格式
-				ip := net.ParseIP(conn.Laddr.IP)
-				if ip != nil {
-					key := fmt.Sprintf("%s:%d", ip.String(), conn.Laddr.Port)
-					tempMap[key] = conn.Pid
+// evictTrafficRecords 删除超过 idle 时长未更新的记录，以及关闭超过 closedFlowTimeout 的TCP连接
+func (e *Engine) evictTrafficRecords(now time.Time, idle time.Duration) {
+	for _, flows := range []*sync.Map{&e.trafficMap, &e.transitMap} {
+		flows.Range(func(key, value interface{}) bool {
+			if record, ok := value.(*TrafficRecord); ok {
+				record.RLock()
+				expired := now.Sub(record.LastUpdate) > idle
+				// 关闭后保留一小段时间，以统计迟到的ACK和重传
+				closed := record.TCPState.IsClosed() && now.Sub(record.ConnEndTime) > e.closedFlowTimeout
+				record.RUnlock()
+				if expired || closed {
+					flows.Delete(key)
 				}
 			}
-		}
-
-		// 原子性更新全局映射
-		for key, pid := range tempMap {
-			connectionMap.Store(key, pid)
-		}
-
-		// 清理过期的连接（可选）
-		connectionMap.Range(func(key, value interface{}) bool {
-			if _, exists := tempMap[key.(string)]; !exists {
-				connectionMap.Delete(key)
-			}
 			return true
 		})
 	}
+	e.pruneGeoCache()
+	e.dnsCache.prune(now)
 }
 
-// 定期清理进程查询缓存
-func cleanupProcessCache() {
-	ticker := time.NewTicker(10 * time.Minute)
+// periodicallyUpdateLocalIPs 定期更新本地IP列表
+func (e *Engine) periodicallyUpdateLocalIPs(ctx context.Context) {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
 	for {
-		<-ticker.C
-		processCacheMutex.Lock()
-		processQueryCache = make(map[string]int32) // 简单清空
-		processCacheMutex.Unlock()
-		log.Debug("已清理进程查询缓存")
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		e.updateLocalIPs()
 	}
 }
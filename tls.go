@@ -0,0 +1,207 @@
+package netguard
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/gopacket/layers"
+)
+
+// TLSInfo 从TLS ClientHello中解析出的信息
+type TLSInfo struct {
+	ServerName string   // SNI，客户端要访问的域名
+	Version    string   // 客户端支持的最高TLS版本，如 "TLS 1.3"
+	ALPN       []string // 客户端支持的应用层协议，如 ["h2", "http/1.1"]
+	JA3        string   // JA3 客户端指纹（MD5）。ClientHello 被截断时为空
+}
+
+// TLS扩展类型
+const (
+	tlsExtServerName        = 0x0000
+	tlsExtSupportedGroups   = 0x000a
+	tlsExtECPointFormats    = 0x000b
+	tlsExtALPN              = 0x0010
+	tlsExtSupportedVersions = 0x002b
+)
+
+// tlsReader 按大端顺序读取字节，数据不足时 ok 置为 false
+type tlsReader struct {
+	b  []byte
+	ok bool
+}
+
+func (r *tlsReader) bytes(n int) []byte {
+	if !r.ok || n < 0 || len(r.b) < n {
+		r.ok = false
+		return nil
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *tlsReader) u8() int {
+	if b := r.bytes(1); b != nil {
+		return int(b[0])
+	}
+	return 0
+}
+
+func (r *tlsReader) u16() int {
+	if b := r.bytes(2); b != nil {
+		return int(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (r *tlsReader) u24() int {
+	if b := r.bytes(3); b != nil {
+		return int(b[0])<<16 | int(b[1])<<8 | int(b[2])
+	}
+	return 0
+}
+
+// isGREASE 判断是否为 RFC 8701 定义的 GREASE 值（0x0a0a、0x1a1a ... 0xfafa），计算指纹时需忽略
+func isGREASE(v int) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+// tlsVersionName TLS版本号对应的名称
+func tlsVersionName(v int) string {
+	switch v {
+	case 0x0300:
+		return "SSL 3.0"
+	case 0x0301:
+		return "TLS 1.0"
+	case 0x0302:
+		return "TLS 1.1"
+	case 0x0303:
+		return "TLS 1.2"
+	case 0x0304:
+		return "TLS 1.3"
+	}
+	return fmt.Sprintf("0x%04x", v)
+}
+
+// parseClientHello 解析TCP连接第一段载荷中的TLS ClientHello。不是ClientHello时返回 false。
+// 超过抓包长度或跨多个TCP段的 ClientHello 会被截断，此时尽量解析已有的扩展，但不计算 JA3
+func parseClientHello(payload []byte) (TLSInfo, bool) {
+	var info TLSInfo
+	// TLS记录头：类型(1) 版本(2) 长度(2)，握手类型(1) 为 ClientHello
+	if len(payload) < 6 || payload[0] != 0x16 || payload[1] != 0x03 || payload[5] != 0x01 {
+		return info, false
+	}
+	r := &tlsReader{b: payload[5:], ok: true}
+	r.u8()  // 握手类型
+	r.u24() // 握手长度
+	clientVersion := r.u16()
+	r.bytes(32)     // random
+	r.bytes(r.u8()) // session id
+	ciphers := r.bytes(r.u16())
+	r.bytes(r.u8()) // 压缩方法
+	if !r.ok {
+		return info, false
+	}
+	info.Version = tlsVersionName(clientVersion)
+
+	// 压缩方法之后没有数据表示没有扩展；扩展长度超出已抓到的数据表示被截断
+	complete := true
+	var exts []byte
+	if len(r.b) > 0 {
+		exts = r.bytes(r.u16())
+		if !r.ok {
+			exts, complete = r.b, false
+		}
+	}
+	var extTypes, groups, pointFormats []int
+	er := &tlsReader{b: exts, ok: true}
+	for len(er.b) > 0 {
+		typ := er.u16()
+		data := er.bytes(er.u16())
+		if !er.ok {
+			complete = false
+			break
+		}
+		extTypes = append(extTypes, typ)
+		d := &tlsReader{b: data, ok: true}
+		switch typ {
+		case tlsExtServerName:
+			list := &tlsReader{b: d.bytes(d.u16()), ok: d.ok}
+			for list.ok && len(list.b) > 0 {
+				nameType := list.u8()
+				name := list.bytes(list.u16())
+				if list.ok && nameType == 0 {
+					info.ServerName = string(name)
+					break
+				}
+			}
+		case tlsExtALPN:
+			list := &tlsReader{b: d.bytes(d.u16()), ok: d.ok}
+			for list.ok && len(list.b) > 0 {
+				if proto := list.bytes(list.u8()); list.ok {
+					info.ALPN = append(info.ALPN, string(proto))
+				}
+			}
+		case tlsExtSupportedVersions:
+			list := &tlsReader{b: d.bytes(d.u8()), ok: d.ok}
+			best := 0
+			for list.ok && len(list.b) >= 2 {
+				if v := list.u16(); !isGREASE(v) && v > best {
+					best = v
+				}
+			}
+			if best > 0 {
+				info.Version = tlsVersionName(best)
+			}
+		case tlsExtSupportedGroups:
+			list := &tlsReader{b: d.bytes(d.u16()), ok: d.ok}
+			for list.ok && len(list.b) >= 2 {
+				groups = append(groups, list.u16())
+			}
+		case tlsExtECPointFormats:
+			for _, f := range d.bytes(d.u8()) {
+				pointFormats = append(pointFormats, int(f))
+			}
+		}
+	}
+	if complete {
+		var cipherList []int
+		for i := 0; i+1 < len(ciphers); i += 2 {
+			cipherList = append(cipherList, int(binary.BigEndian.Uint16(ciphers[i:])))
+		}
+		info.JA3 = ja3Hash(clientVersion, cipherList, extTypes, groups, pointFormats)
+	}
+	return info, true
+}
+
+// ja3Hash 计算 JA3 指纹：MD5("版本,加密套件,扩展,椭圆曲线,点格式")，各列表内用 "-" 连接，忽略 GREASE 值
+func ja3Hash(version int, ciphers, exts, groups, pointFormats []int) string {
+	join := func(vs []int) string {
+		var parts []string
+		for _, v := range vs {
+			if !isGREASE(v) {
+				parts = append(parts, strconv.Itoa(v))
+			}
+		}
+		return strings.Join(parts, "-")
+	}
+	s := strings.Join([]string{strconv.Itoa(version), join(ciphers), join(exts), join(groups), join(pointFormats)}, ",")
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// updateTLS 在TCP连接的第一段载荷中查找TLS ClientHello。调用方需持有写锁。
+// 只检查第一段载荷：TLS连接由客户端先发送ClientHello，之后的数据都是加密的
+func (tr *TrafficRecord) updateTLS(tcp *layers.TCP) {
+	if tr.tcp.payload || len(tcp.Payload) == 0 {
+		return
+	}
+	tr.tcp.payload = true
+	if info, ok := parseClientHello(tcp.Payload); ok {
+		tr.TLS = info
+	}
+}
@@ -0,0 +1,124 @@
+package netguard
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"net"
+	"slices"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// tlsVector 加上长度前缀，size 为长度字段的字节数
+func tlsVector(size int, data ...[]byte) []byte {
+	var body []byte
+	for _, d := range data {
+		body = append(body, d...)
+	}
+	n := len(body)
+	prefix := make([]byte, size)
+	for i := size - 1; i >= 0; i-- {
+		prefix[i] = byte(n)
+		n >>= 8
+	}
+	return append(prefix, body...)
+}
+
+func tlsU16(vs ...int) []byte {
+	var b []byte
+	for _, v := range vs {
+		b = append(b, byte(v>>8), byte(v))
+	}
+	return b
+}
+
+// tlsExt 构造TLS扩展：类型(2) + 长度(2) + 数据
+func tlsExt(typ int, data ...[]byte) []byte {
+	return append(tlsU16(typ), tlsVector(2, data...)...)
+}
+
+// newClientHello 构造带GREASE值的TLS记录，模拟浏览器发送的ClientHello
+func newClientHello(serverName string) []byte {
+	exts := [][]byte{
+		tlsExt(0x1a1a),
+		tlsExt(tlsExtServerName, tlsVector(2, []byte{0}, tlsVector(2, []byte(serverName)))),
+		tlsExt(tlsExtSupportedGroups, tlsVector(2, tlsU16(0x2a2a, 0x001d, 0x0017))),
+		tlsExt(tlsExtECPointFormats, tlsVector(1, []byte{0})),
+		tlsExt(tlsExtALPN, tlsVector(2, tlsVector(1, []byte("h2")), tlsVector(1, []byte("http/1.1")))),
+		tlsExt(tlsExtSupportedVersions, tlsVector(1, tlsU16(0x3a3a, 0x0304, 0x0303))),
+	}
+	hello := append(tlsU16(0x0303), make([]byte, 32)...)
+	hello = append(hello, tlsVector(1, make([]byte, 32))...)
+	hello = append(hello, tlsVector(2, tlsU16(0x0a0a, 0x1301, 0x1302, 0xc02b))...)
+	hello = append(hello, tlsVector(1, []byte{0})...)
+	hello = append(hello, tlsVector(2, exts...)...)
+	handshake := append([]byte{0x01}, tlsVector(3, hello)...)
+	return append([]byte{0x16, 0x03, 0x01}, tlsVector(2, handshake)...)
+}
+
+// 添加测试：解析ClientHello中的SNI、版本、ALPN，计算JA3时忽略GREASE值
+func TestParseClientHello(t *testing.T) {
+	hello := newClientHello("www.example.com")
+	info, ok := parseClientHello(hello)
+	if !ok {
+		t.Fatal("应识别为ClientHello")
+	}
+	if info.ServerName != "www.example.com" || info.Version != "TLS 1.3" || !slices.Equal(info.ALPN, []string{"h2", "http/1.1"}) {
+		t.Fatalf("解析结果不正确: %+v", info)
+	}
+	sum := md5.Sum([]byte("771,4865-4866-49195,0-10-11-16-43,29-23,0"))
+	if info.JA3 != hex.EncodeToString(sum[:]) {
+		t.Fatalf("JA3 不正确: %s", info.JA3)
+	}
+
+	// 被截断的ClientHello：已抓到的扩展照常解析，但扩展不完整，不计算JA3
+	info, ok = parseClientHello(hello[:len(hello)-10])
+	if !ok || info.ServerName != "www.example.com" || info.JA3 != "" {
+		t.Fatalf("截断的ClientHello应解析出SNI且JA3为空，实际 %v %+v", ok, info)
+	}
+
+	for _, payload := range [][]byte{[]byte("GET / HTTP/1.1\r\n"), hello[:20], nil} {
+		if _, ok := parseClientHello(payload); ok {
+			t.Fatalf("%q 不应识别为ClientHello", payload)
+		}
+	}
+}
+
+// 添加测试：TLS信息只取自连接的第一段载荷，端口复用后重新解析
+func TestTrafficRecordTLS(t *testing.T) {
+	e := NewEngine(WithProcessResolver(newMockResolver()), WithLocalIPs(net.ParseIP("192.168.1.10")))
+	send := func(data []byte) {
+		e.processCapturedPacket(gopacket.NewPacket(data, layers.LinkTypeEthernet, gopacket.Default), "")
+	}
+	tlsOf := func() TLSInfo {
+		stats := e.GetTrafficStats()
+		if len(stats) != 1 {
+			t.Fatalf("应有 1 条记录，实际 %d", len(stats))
+		}
+		return stats[0].TLS
+	}
+
+	send(newTCPPacket(t, "192.168.1.10", "93.184.216.34", 50000, 443, &layers.TCP{SYN: true, Seq: 100}, nil))
+	send(newTCPPacket(t, "192.168.1.10", "93.184.216.34", 50000, 443, &layers.TCP{ACK: true, Seq: 101}, newClientHello("a.example.com")))
+	if info := tlsOf(); info.ServerName != "a.example.com" || info.JA3 == "" {
+		t.Fatalf("应记录第一段载荷中的ClientHello，实际 %+v", info)
+	}
+	// 之后的载荷不再解析
+	send(newTCPPacket(t, "192.168.1.10", "93.184.216.34", 50000, 443, &layers.TCP{ACK: true, Seq: 2000}, newClientHello("b.example.com")))
+	if info := tlsOf(); info.ServerName != "a.example.com" {
+		t.Fatalf("只应解析第一段载荷，实际 %+v", info)
+	}
+
+	// 连接结束后端口被复用，重新解析新连接的ClientHello
+	send(newTCPPacket(t, "192.168.1.10", "93.184.216.34", 50000, 443, &layers.TCP{RST: true, Seq: 3000}, nil))
+	send(newTCPPacket(t, "192.168.1.10", "93.184.216.34", 50000, 443, &layers.TCP{SYN: true, Seq: 5000}, nil))
+	if info := tlsOf(); info.ServerName != "" {
+		t.Fatalf("端口复用后应清空TLS信息，实际 %+v", info)
+	}
+	send(newTCPPacket(t, "192.168.1.10", "93.184.216.34", 50000, 443, &layers.TCP{ACK: true, Seq: 5001}, newClientHello("b.example.com")))
+	if info := tlsOf(); info.ServerName != "b.example.com" {
+		t.Fatalf("应解析新连接的ClientHello，实际 %+v", info)
+	}
+}
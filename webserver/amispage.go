@@ -3,6 +3,7 @@ package webserver
 import (
 	"github.com/iotames/easyserver/httpsvr"
 	"github.com/iotames/easyserver/response"
+	"github.com/iotames/netguard"
 	"github.com/iotames/netguard/device"
 	"github.com/iotames/netguard/webserver/amis"
 )
@@ -10,9 +11,12 @@ import (
 func getAmisPageConfig(ctx httpsvr.Context) {
 	defaultDev := device.GetDefaultDevice()
 	pageConf := amis.NewPage(AppTitle)
-	item1 := amis.NewFormItem().Set("label", "监控网卡").Set("type", "select").Set("name", "devname").Set("value", defaultDev.Name).Set("source", "/api/device/list")
+	item1 := amis.NewFormItem().Set("label", "监控网卡").Set("type", "select").Set("multiple", true).Set("name", "devname").Set("value", defaultDev.Name).Set("source", "/api/device/list")
+	filterItem := amis.NewFormItem().Set("label", "BPF过滤器").Set("type", "input-text").Set("name", "filter").Set("value", netguard.DefaultEngine().BPFFilter()).Set("placeholder", "语法同tcpdump，如: tcp port 443 or udp port 53")
+	loopbackItem := amis.NewFormItem().Set("label", "监控环回网卡").Set("type", "switch").Set("name", "loopback").Set("value", netguard.DefaultEngine().Loopback()).Set("description", "统计本机进程之间(如访问127.0.0.1)的流量")
 	// item2 := amis.NewFormItem().Set("type", "input-file").Set("name", "inputfile").Set("accept", ".xlsx").Set("label", "上传.xlsx文件").Set("maxSize", 10048576).Set("receiver", "/api/uploadfile")
-	pageConf.Body = *amis.NewForm("/api/netguard/start").AddItem(item1).SetSubmitText("启动")
+	stopBtn := amis.NewFormItem().Set("type", "button").Set("label", "停止").Set("actionType", "ajax").Set("api", "post:/api/netguard/stop")
+	pageConf.Body = *amis.NewForm("/api/netguard/start").AddItem(item1).AddItem(filterItem).AddItem(loopbackItem).AddItem(stopBtn).SetSubmitText("启动")
 	// .SetTitle("AppTitle")
 	// .AddItem(item2)
 	ctx.Writer.Write(response.NewApiData(pageConf.Json(), "success", 0).Bytes())
@@ -1,7 +1,10 @@
 package webserver
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"strconv"
 	"sync"
 
 	e "github.com/iotames/easyserver"
@@ -11,6 +14,7 @@ import (
 	"github.com/iotames/netguard/db"
 	"github.com/iotames/netguard/device"
 	"github.com/iotames/netguard/hotswap"
+	"github.com/iotames/netguard/log"
 )
 
 var AppTitle = "NetGuard网络流量监控"
@@ -36,83 +40,162 @@ func setHandler(svr *httpsvr.EasyServer) {
 	svr.AddHandler("GET", "/api/device/list", deviceList)
 	svr.AddHandler("GET", "/api/amis-page-config", getAmisPageConfig)
 	svr.AddHandler("POST", "/api/netguard/start", netguardStart)
+	svr.AddHandler("POST", "/api/netguard/stop", netguardStop)
+	svr.AddHandler("GET", "/api/stats/process", processStats)
+	svr.AddHandler("GET", "/api/stats/remote", remoteHostStats)
+	svr.AddHandler("GET", "/api/stats/country", countryStats)
+	svr.AddHandler("GET", "/api/stats/asn", asnStats)
+	svr.AddHandler("GET", "/api/stats/user", userStats)
+	svr.AddHandler("GET", "/api/stats/interface", interfaceStats)
+	svr.AddHandler("GET", "/api/stats/transit", transitStats)
 }
 
 type NetguardConf struct {
-	DevName string `json:"devname"`
+	DevName  string `json:"devname"`  // 多个网卡用逗号分隔，all 表示所有非环回网卡
+	Filter   string `json:"filter"`   // BPF过滤器，为空时使用 netguard.DefaultBPFFilter
+	Loopback bool   `json:"loopback"` // 是否同时监控环回网卡
 }
 
-var startConf NetguardConf
-var netguardStarted bool
+// netguardMutex 保证同时只处理一个启动请求
+var netguardMutex sync.Mutex
 
 func netguardStart(ctx httpsvr.Context) {
-	fmt.Printf("---startConf11(%+v)-----\n", startConf)
-	if netguardStarted {
+	netguardMutex.Lock()
+	defer netguardMutex.Unlock()
+	if netguard.DefaultEngine().IsRunning() {
 		e.ResponseJsonFail(ctx, "请先停止后启动", 500)
 		return
 	}
 
+	// 每次请求单独解析，未传的字段使用零值，不沿用上一次启动的配置
+	var startConf NetguardConf
 	err := ctx.GetPostJson(&startConf)
 	if err != nil {
 		e.ResponseJsonFail(ctx, err.Error(), 500)
 		return
 	}
 	fmt.Printf("---startConf22(%+v)-----\n", startConf)
-	go func() {
-		netguardStarted = true
-
-		d := db.GetDb()
-
-		// 使用sync.Map替代map，避免出现concurrent map writes错误
-		var ipinfomap = &sync.Map{}
-
-		netguard.SetPacketHook(func(info *netguard.TrafficRecord) {
-			remoteIp := info.RemoteIP.String()
-			// 跳过本地IP的处理
-			if netguard.IsNativeIP(remoteIp) {
-				return
-			}
-			var ipinfo netguard.GeoIpInfo
-			// 使用sync.Map的方法
-			if remoteInfo, ok := ipinfomap.Load(remoteIp); ok {
-				ipinfo = remoteInfo.(netguard.GeoIpInfo)
-				// totalMB := float64(info.BytesReceived+info.BytesSent) / 1024.0 / 1024.0
-				// logmsg := fmt.Sprintf("流量概要:%s, 流量:%.2fMB, IP解析:%s", info.Msg, totalMB, remoteInfostr)
-				// fmt.Printf("%s\n", logmsg)
-				// log.Info("PacketHook", "logmsg", logmsg)
-
-			} else {
-				ipinfo = netguard.GetIpGeo(remoteIp)
-				// remoteInfostr := fmt.Sprintf("%s %s", ipinfo.Country, ipinfo.City)
-				ipinfomap.Store(remoteIp, ipinfo)
-				// totalMB := float64(info.BytesReceived+info.BytesSent) / 1024.0 / 1024.0
-				// logmsg := fmt.Sprintf("流量概要:%s, 流量:%.2fMB, IP解析:%s", info.Msg, totalMB, remoteInfostr)
-				// fmt.Printf("%s\n", logmsg)
-				// log.Info("PacketHook", "logmsg", logmsg)
-
-			}
-			_, err = d.Exec(`INSERT INTO ng_hook_logs (
-            remote_ip, remote_port, protocol,
-			process_name, process_pid,
+	err = netguard.ValidateBPFFilter(startConf.Filter)
+	if err != nil {
+		e.ResponseJsonFail(ctx, err.Error(), 500)
+		return
+	}
+	netguard.DefaultEngine().SetBPFFilter(startConf.Filter)
+	netguard.DefaultEngine().SetLoopback(startConf.Loopback)
+
+	d := db.GetDb()
+
+	netguard.SetPacketHook(func(info *netguard.TrafficRecord) {
+		remoteIp := info.RemoteIP.String()
+		// 跳过本地IP的处理
+		if netguard.IsNativeIP(remoteIp) {
+			return
+		}
+		// 地理位置由引擎统一查询并缓存
+		ipinfo := netguard.DefaultEngine().GetRemoteGeo(remoteIp)
+		_, err := d.Exec(`INSERT INTO ng_hook_logs (
+            remote_ip, remote_host, remote_port, protocol,
+			process_name, process_pid, container_id,
             bytes_current_len, inbound,
             ip_country, ip_city
-        ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-				remoteIp, info.RemotePort, info.Protocol,
-				info.ProcessName, info.ProcessPID,
-				info.BytesCurrentLen, info.Inbound,
-				ipinfo.Country, ipinfo.City,
-			)
+        ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			remoteIp, info.RemoteHost, info.RemotePort, info.Protocol,
+			info.ProcessName, info.ProcessPID, info.ContainerID,
+			info.BytesCurrentLen, info.Inbound,
+			ipinfo.Country, ipinfo.City,
+		)
+
+		if err != nil {
+			fmt.Println("sql error:", err.Error())
+		}
+
+	})
+
+	// 明文HTTP请求单独保存，记录请求地址和请求头
+	netguard.SetHTTPRequestHook(func(req *netguard.HTTPRequest) {
+		headers, _ := json.Marshal(req.Headers)
+		_, err := d.Exec(`INSERT INTO ng_http_requests (
+            client_ip, client_port, server_ip, server_port,
+            method, host, request_url, user_agent, http_referer, x_forwarded_for,
+            request_headers, process_name, process_pid
+        ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			req.ClientIP.String(), req.ClientPort, req.ServerIP.String(), req.ServerPort,
+			req.Method, req.Host, req.URL, req.UserAgent, req.Referer, req.XForwardedFor,
+			string(headers), req.ProcessName, req.ProcessPID,
+		)
+		if err != nil {
+			fmt.Println("sql error:", err.Error())
+		}
+	})
+
+	// 打开网卡后才返回：打开网卡或设置过滤器失败时返回错误，成功时引擎已在运行，之后的停止请求可以停止本次抓包
+	err = netguard.Start(context.Background(), startConf.DevName)
+	if err != nil {
+		log.Error("netguard.Start fail", "error", err.Error(), "devname", startConf.DevName)
+		e.ResponseJsonFail(ctx, err.Error(), 500)
+		return
+	}
+	e.ResponseJsonOk(ctx, "启动成功")
+}
 
-			if err != nil {
-				fmt.Println("sql error:", err.Error())
-			}
+func netguardStop(ctx httpsvr.Context) {
+	if !netguard.DefaultEngine().IsRunning() {
+		e.ResponseJsonFail(ctx, "监控未启动", 500)
+		return
+	}
+	// 阻塞直到抓包句柄关闭、剩余数据包处理完毕
+	netguard.Stop()
+	e.ResponseJsonOk(ctx, "停止成功")
+}
 
-		})
+// processStats 按进程汇总的流量。查询参数 group=app 时按顶层应用汇总，group=container 时按容器汇总
+func processStats(ctx httpsvr.Context) {
+	groupBy := netguard.GroupByProcess
+	switch ctx.Request.URL.Query().Get("group") {
+	case "app":
+		groupBy = netguard.GroupByApp
+	case "container":
+		groupBy = netguard.GroupByContainer
+	}
+	items := netguard.GetProcessStatsBy(groupBy)
+	ctx.Writer.Write(response.NewApiData(response.JsonObject{"items": items, "total": len(items)}, "success", 0).Bytes())
+}
 
-		netguard.Run(startConf.DevName)
+// transitStats 转发流量，两端都不是本机IP或本地网络
+func transitStats(ctx httpsvr.Context) {
+	items := netguard.GetTransitStats()
+	ctx.Writer.Write(response.NewApiData(response.JsonObject{"items": items, "total": len(items)}, "success", 0).Bytes())
+}
 
-	}()
-	e.ResponseJsonOk(ctx, "启动成功")
+func interfaceStats(ctx httpsvr.Context) {
+	items := netguard.GetInterfaceStats()
+	ctx.Writer.Write(response.NewApiData(response.JsonObject{"items": items, "total": len(items)}, "success", 0).Bytes())
+}
+
+func userStats(ctx httpsvr.Context) {
+	items := netguard.GetUserStats()
+	ctx.Writer.Write(response.NewApiData(response.JsonObject{"items": items, "total": len(items)}, "success", 0).Bytes())
+}
+
+// getTopN 读取查询参数 top，缺省或无效时返回0，表示不限制条数
+func getTopN(ctx httpsvr.Context) int {
+	topN, _ := strconv.Atoi(ctx.Request.URL.Query().Get("top"))
+	return topN
+}
+
+func remoteHostStats(ctx httpsvr.Context) {
+	items := netguard.GetRemoteHostStats(getTopN(ctx))
+	ctx.Writer.Write(response.NewApiData(response.JsonObject{"items": items, "total": len(items)}, "success", 0).Bytes())
+}
+
+func countryStats(ctx httpsvr.Context) {
+	items := netguard.GetCountryStats(getTopN(ctx))
+	ctx.Writer.Write(response.NewApiData(response.JsonObject{"items": items, "total": len(items)}, "success", 0).Bytes())
+}
+
+func asnStats(ctx httpsvr.Context) {
+	items := netguard.GetAsnStats(getTopN(ctx))
+	ctx.Writer.Write(response.NewApiData(response.JsonObject{"items": items, "total": len(items)}, "success", 0).Bytes())
 }
 
 func setlogfile(ctx httpsvr.Context) {
@@ -127,9 +210,10 @@ func setlogfile(ctx httpsvr.Context) {
 func deviceList(ctx httpsvr.Context) {
 	devlist := device.GetDeviceList()
 
-	options := make([]map[string]string, len(devlist))
-	for i, v := range devlist {
-		options[i] = map[string]string{"label": v.Description, "value": v.Name}
+	options := make([]map[string]string, 0, len(devlist)+1)
+	options = append(options, map[string]string{"label": "所有非环回网卡", "value": netguard.AllDevices})
+	for _, v := range devlist {
+		options = append(options, map[string]string{"label": v.Description, "value": v.Name})
 	}
 	// json返回
 	ctx.Writer.Write(response.NewApiData(response.JsonObject{"options": options}, "success", 0).Bytes())